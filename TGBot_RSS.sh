@@ -53,6 +53,9 @@ chmod +x TGBot_RSS
 echo "删除压缩包: $PKG"
 rm -f "$PKG"
 echo "完成，请修改 TGBot_RSS 的配置文件: config.json"
+if [ -f tgbot.db ]; then
+    echo "已有数据库 tgbot.db，启动时会自动升级，升级前的数据库会备份为 tgbot.db.v*.bak"
+fi
 echo "之后再次运行: ./TGBot_RSS"
 echo "后台运行可输入：nohup ./TGBot_RSS > /dev/null 2>&1 &"
 rm -f "TGBot_RSS.sh"
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// 推送按钮相关常量
+const (
+	PushActionPrefix    = "pi_"               // 推送按钮回调数据前缀
+	PushActionMuteFeed  = "mf"                // 屏蔽此源
+	PushActionDelKw     = "dk"                // 删除命中关键词
+	PushActionBlockWord = "bw"                // 添加屏蔽词
+	PushActionSave      = "sv"                // 收藏
+	FeedMuteDuration    = 24 * time.Hour      // 屏蔽此源的时长
+	PushedItemRetention = 30 * 24 * time.Hour // 推送记录保留时长，超过后按钮失效
+	CallbackSignLength  = 6                   // 回调签名字节数，编码后8个字符
+)
+
+// pushedItem 已推送的条目，供推送按钮使用
+type pushedItem struct {
+	ID          int64
+	UserID      int64
+	FeedID      int
+	FeedName    string
+	Title       string
+	Link        string
+	Description string
+	Keywords    []string // 命中的原始关键词
+	PublishedAt string
+}
+
+// callbackKey 由BotToken派生回调签名密钥
+func callbackKey() []byte {
+	sum := sha256.Sum256([]byte("TGBot_RSS callback:" + globalConfig.BotToken))
+	return sum[:]
+}
+
+// signCallback 为回调数据签名，签名包含用户ID，其他用户无法复用
+func signCallback(userID int64, payload string) string {
+	mac := hmac.New(sha256.New, callbackKey())
+	fmt.Fprintf(mac, "%d:%s", userID, payload)
+	return payload + "_" + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:CallbackSignLength])
+}
+
+// verifyCallback 校验回调签名，返回签名前的数据
+func verifyCallback(userID int64, data string) (string, bool) {
+	i := strings.LastIndex(data, "_")
+	if i < 0 {
+		return "", false
+	}
+	payload := data[:i]
+	return payload, hmac.Equal([]byte(signCallback(userID, payload)), []byte(data))
+}
+
+// pushActionData 生成推送按钮的回调数据，格式：pi_<操作>_<推送ID>[_<参数>]_<签名>
+func pushActionData(userID int64, action string, itemID int64, arg ...string) string {
+	payload := fmt.Sprintf("%s%s_%d", PushActionPrefix, action, itemID)
+	if len(arg) > 0 {
+		payload += "_" + arg[0]
+	}
+	return signCallback(userID, payload)
+}
+
+// keywordToken 关键词的短标识，用于删除关键词按钮，避免回调数据超过64字节
+func keywordToken(keyword string) string {
+	sum := sha256.Sum256([]byte(keyword))
+	return base64.RawURLEncoding.EncodeToString(sum[:8])
+}
+
+// findKeywordByToken 在关键词列表中查找短标识对应的关键词
+func findKeywordByToken(keywords []string, token string) (string, bool) {
+	for _, keyword := range keywords {
+		if keywordToken(keyword) == token {
+			return keyword, true
+		}
+	}
+	return "", false
+}
+
+// ruleRaws 返回命中规则的原始关键词，全部推送模式的规则不是用户添加的关键词
+func ruleRaws(rules []*keywordRule) []string {
+	var raws []string
+	for _, rule := range rules {
+		if rule != matchAllRule {
+			raws = append(raws, rule.Raw)
+		}
+	}
+	return raws
+}
+
+// recordPushedItem 记录一条推送，返回推送ID
+func recordPushedItem(e execer, item pushedItem) (int64, error) {
+	keywordsJSON, err := json.Marshal(item.Keywords)
+	if err != nil {
+		return 0, err
+	}
+	if item.Keywords == nil {
+		keywordsJSON = []byte("[]")
+	}
+	result, err := e.Exec(`INSERT INTO pushed_items (user_id, feed_id, feed_name, title, link, description, keywords, published_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		item.UserID, item.FeedID, item.FeedName, item.Title, item.Link, item.Description, string(keywordsJSON),
+		item.PublishedAt, time.Now().UTC().Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// getPushedItem 获取用户的推送记录
+func getPushedItem(db *sql.DB, userID int64, itemID int64) (*pushedItem, error) {
+	item := &pushedItem{ID: itemID, UserID: userID}
+	var keywordsJSON string
+	err := db.QueryRow(`SELECT feed_id, feed_name, title, link, description, keywords, published_at
+		FROM pushed_items WHERE pushed_item_id = ? AND user_id = ?`, itemID, userID).
+		Scan(&item.FeedID, &item.FeedName, &item.Title, &item.Link, &item.Description, &keywordsJSON, &item.PublishedAt)
+	if err != nil {
+		return nil, err
+	}
+	// 关键词只用于屏蔽按钮，解析失败时记录日志，推送记录本身仍可收藏和查看
+	if err := json.Unmarshal([]byte(keywordsJSON), &item.Keywords); err != nil {
+		logMessage("warn", fmt.Sprintf("推送记录 %d 的关键词解析失败: %v", itemID, err), userID)
+		item.Keywords = nil
+	}
+	return item, nil
+}
+
+// prunePushedItems 清理过期的推送记录
+func prunePushedItems(db *sql.DB) {
+	cutoff := time.Now().UTC().Add(-PushedItemRetention).Format("2006-01-02 15:04:05")
+	if _, err := db.Exec("DELETE FROM pushed_items WHERE created_at < ?", cutoff); err != nil {
+		logMessage("error", fmt.Sprintf("清理推送记录失败: %v", err))
+	}
+}
+
+// createPushKeyboard 创建推送消息下方的操作按钮
+func createPushKeyboard(item pushedItem) tgbotapi.InlineKeyboardMarkup {
+	var rows [][]tgbotapi.InlineKeyboardButton
+	first := []tgbotapi.InlineKeyboardButton{
+		tgbotapi.NewInlineKeyboardButtonData("⭐ 收藏", pushActionData(item.UserID, PushActionSave, item.ID)),
+	}
+	if u, err := url.Parse(item.Link); err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" {
+		first = append([]tgbotapi.InlineKeyboardButton{tgbotapi.NewInlineKeyboardButtonURL("🔗 打开原文", item.Link)}, first...)
+	}
+	rows = append(rows, first)
+
+	second := []tgbotapi.InlineKeyboardButton{
+		tgbotapi.NewInlineKeyboardButtonData("🔇 屏蔽此源1天", pushActionData(item.UserID, PushActionMuteFeed, item.ID)),
+	}
+	if len(item.Keywords) > 0 {
+		second = append(second, tgbotapi.NewInlineKeyboardButtonData("🗑️ 删除命中关键词", pushActionData(item.UserID, PushActionDelKw, item.ID)))
+	}
+	rows = append(rows, second)
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("🚫 添加屏蔽词", pushActionData(item.UserID, PushActionBlockWord, item.ID)),
+	))
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+// pushReplyMarkup 记录推送并返回序列化的操作按钮，失败时推送不带按钮
+func pushReplyMarkup(e execer, item pushedItem) string {
+	id, err := recordPushedItem(e, item)
+	if err != nil {
+		logMessage("error", fmt.Sprintf("记录推送失败: %v", err), item.UserID)
+		return ""
+	}
+	item.ID = id
+	markup, err := json.Marshal(createPushKeyboard(item))
+	if err != nil {
+		return ""
+	}
+	return string(markup)
+}
+
+// answerCallback 回应按钮点击，text不为空时显示提示
+func answerCallback(callbackID, text string) {
+	if _, err := bot.Request(tgbotapi.NewCallback(callbackID, text)); err != nil {
+		logMessage("error", fmt.Sprintf("回应回调查询失败: %v", err))
+	}
+}
+
+// handlePushAction 处理推送消息上的按钮
+func handlePushAction(callbackQuery *tgbotapi.CallbackQuery) {
+	userID := callbackQuery.From.ID
+	payload, ok := verifyCallback(userID, callbackQuery.Data)
+	if !ok {
+		logMessage("warn", fmt.Sprintf("推送按钮签名无效: %s", callbackQuery.Data), userID)
+		answerCallback(callbackQuery.ID, "按钮已失效")
+		return
+	}
+
+	// 格式：pi_<操作>_<推送ID>[_<参数>]
+	parts := strings.Split(strings.TrimPrefix(payload, PushActionPrefix), "_")
+	if len(parts) < 2 {
+		answerCallback(callbackQuery.ID, "按钮已失效")
+		return
+	}
+	itemID, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		answerCallback(callbackQuery.ID, "按钮已失效")
+		return
+	}
+
+	var item *pushedItem
+	err = withDB(func(db *sql.DB) error {
+		item, err = getPushedItem(db, userID, itemID)
+		return err
+	})
+	if err == sql.ErrNoRows {
+		answerCallback(callbackQuery.ID, "该推送已过期，请在菜单中操作")
+		return
+	}
+	if err != nil {
+		logMessage("error", fmt.Sprintf("获取推送记录失败: %v", err), userID)
+		answerCallback(callbackQuery.ID, "操作失败，请稍后重试")
+		return
+	}
+
+	switch parts[0] {
+	case PushActionMuteFeed:
+		answerCallback(callbackQuery.ID, muteFeedForUser(userID, item))
+	case PushActionDelKw:
+		arg := ""
+		if len(parts) > 2 {
+			arg = parts[2]
+		}
+		answerCallback(callbackQuery.ID, deletePushedKeyword(userID, item, arg))
+	case PushActionBlockWord:
+		answerCallback(callbackQuery.ID, "")
+		setUserState(userID, "add_block_word", 0, nil)
+		keyboard := CreateBackButton()
+		messageSender.SendResponse(userID, 0, fmt.Sprintf("请输入要屏蔽的词，多个词可用逗号分隔或分行输入，将作为全局屏蔽词添加（自动加 - 前缀）\n\n"+
+			"💡 只想在 \"%s\" 中屏蔽，可在词后加 +%s", item.FeedName, item.FeedName), &keyboard)
+	case PushActionSave:
+		answerCallback(callbackQuery.ID, savePushedItem(userID, item))
+	default:
+		answerCallback(callbackQuery.ID, "按钮已失效")
+	}
+}
+
+// muteFeedForUser 暂停向用户推送该订阅一段时间
+func muteFeedForUser(userID int64, item *pushedItem) string {
+	until := time.Now().UTC().Add(FeedMuteDuration)
+	var affected int64
+	err := withDB(func(db *sql.DB) error {
+		result, err := db.Exec("UPDATE user_subscriptions SET muted_until = ? WHERE user_id = ? AND feed_id = ?",
+			until.Format("2006-01-02 15:04:05"), userID, item.FeedID)
+		if err != nil {
+			return err
+		}
+		affected, err = result.RowsAffected()
+		return err
+	})
+	if err != nil {
+		logMessage("error", fmt.Sprintf("屏蔽订阅失败: %v", err), userID)
+		return "操作失败，请稍后重试"
+	}
+	if affected == 0 {
+		return "你已不再订阅该源"
+	}
+	logMessage("info", fmt.Sprintf("用户屏蔽订阅 %s 至 %s", item.FeedName, until.Format("2006-01-02 15:04:05")), userID)
+	return fmt.Sprintf("🔇 已屏蔽 \"%s\" 至 %s", item.FeedName, until.In(userLocation(userID)).Format("01-02 15:04"))
+}
+
+// unmuteFeedForUser 恢复推送已屏蔽的订阅
+func unmuteFeedForUser(userID int64, feedID int) error {
+	return withDB(func(db *sql.DB) error {
+		_, err := db.Exec("UPDATE user_subscriptions SET muted_until = '' WHERE user_id = ? AND feed_id = ?", userID, feedID)
+		return err
+	})
+}
+
+// deletePushedKeyword 删除推送命中的关键词，命中多个时发送选择按钮
+func deletePushedKeyword(userID int64, item *pushedItem, arg string) string {
+	if len(item.Keywords) == 0 {
+		return "该推送没有可删除的关键词"
+	}
+	if arg == "" && len(item.Keywords) > 1 {
+		var rows [][]tgbotapi.InlineKeyboardButton
+		for i, keyword := range item.Keywords {
+			rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData("❌ "+keyword, pushActionData(userID, PushActionDelKw, item.ID, strconv.Itoa(i))),
+			))
+		}
+		keyboard := tgbotapi.NewInlineKeyboardMarkup(rows...)
+		messageSender.SendResponse(userID, 0, fmt.Sprintf("\"%s\" 命中了多个关键词，请选择要删除的关键词：", item.Title), &keyboard)
+		return ""
+	}
+
+	index := 0
+	if arg != "" {
+		i, err := strconv.Atoi(arg)
+		if err != nil || i < 0 || i >= len(item.Keywords) {
+			return "按钮已失效"
+		}
+		index = i
+	}
+	keyword := item.Keywords[index]
+	removed, err := removeMatchedKeyword(userID, item.FeedID, keyword)
+	if err != nil {
+		logMessage("error", fmt.Sprintf("删除关键词失败: %v", err), userID)
+		return "删除关键词失败，请稍后重试"
+	}
+	if !removed {
+		return fmt.Sprintf("关键词 \"%s\" 已不存在", keyword)
+	}
+	logMessage("info", fmt.Sprintf("通过推送按钮删除关键词: %s", keyword), userID)
+	return fmt.Sprintf("🗑️ 已删除关键词 \"%s\"", keyword)
+}
+
+// removeMatchedKeyword 从全局关键词或订阅专属关键词中删除关键词
+func removeMatchedKeyword(userID int64, feedID int, keyword string) (bool, error) {
+	keywords, err := getKeywordsForUser(userID)
+	if err != nil {
+		return false, err
+	}
+	for _, k := range keywords {
+		if k == keyword {
+			_, err := removeKeywordForUser(userID, keyword)
+			return err == nil, err
+		}
+	}
+
+	filter, err := getSubscriptionFilter(userID, feedID)
+	if err != nil || filter.Mode != FilterCustom {
+		return false, err
+	}
+	var remaining []string
+	for _, k := range filter.Keywords {
+		if k != keyword {
+			remaining = append(remaining, k)
+		}
+	}
+	if len(remaining) == len(filter.Keywords) {
+		return false, nil
+	}
+	return true, setSubscriptionKeywords(userID, feedID, remaining)
+}
+
+// savePushedItem 收藏推送的条目
+func savePushedItem(userID int64, item *pushedItem) string {
+	saved := savedItem{FeedName: item.FeedName, Title: item.Title, Link: item.Link, Description: item.Description}
+	saved.PublishedAt, _ = time.Parse("2006-01-02 15:04:05", item.PublishedAt)
+	var added bool
+	err := withDB(func(db *sql.DB) error {
+		var err error
+		added, err = addSavedItem(db, userID, saved)
+		return err
+	})
+	if err != nil {
+		logMessage("error", fmt.Sprintf("收藏失败: %v", err), userID)
+		return "收藏失败，请稍后重试"
+	}
+	if !added {
+		return "已经收藏过了"
+	}
+	return "⭐ 已收藏"
+}
+
+// handleBlockWordInput 处理通过推送按钮添加屏蔽词的输入
+func handleBlockWordInput(message *tgbotapi.Message) {
+	userID := message.From.ID
+	var keywords []string
+	for _, word := range splitKeywordInput(strings.TrimSpace(message.Text)) {
+		if !strings.HasPrefix(word, "-") {
+			word = "-" + word
+		}
+		keywords = append(keywords, word)
+	}
+	if len(keywords) == 0 {
+		messageSender.SendError(userID, 0, "❌ 请输入要屏蔽的词")
+		return
+	}
+	if problems := validateKeywords(keywords); len(problems) > 0 {
+		messageSender.SendError(userID, 0, fmt.Sprintf("❌ 屏蔽词格式错误，本次未保存：\n%s", strings.Join(problems, "\n")))
+		return
+	}
+	actionHandler.HandleAction(userID, 0, "keyword", "add", keywords...)
+}
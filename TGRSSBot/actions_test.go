@@ -0,0 +1,98 @@
+package main
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+)
+
+func TestGetPushedItem(t *testing.T) {
+	db, err := sql.Open("sqlite3", t.TempDir()+"/actions.db?_foreign_keys=on")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := migrateSchema(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := execAll(db,
+		"INSERT INTO feeds (feed_id, rss_url, rss_name) VALUES (1, 'https://example.com/feed', '科技')",
+		"INSERT INTO users (user_id, created_at) VALUES (100, '2024-01-01 00:00:00')",
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	item := pushedItem{UserID: 100, FeedID: 1, FeedName: "科技", Title: "标题", Link: "https://example.com/1",
+		Keywords: []string{"显卡", "#t 4090"}, PublishedAt: "2024-01-01 00:00:00"}
+	id, err := recordPushedItem(db, item)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := getPushedItem(db, 100, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.Keywords, item.Keywords) || got.Title != item.Title {
+		t.Errorf("getPushedItem = %+v, want keywords %q", got, item.Keywords)
+	}
+
+	// 其他用户不能读取
+	if _, err := getPushedItem(db, 200, id); err != sql.ErrNoRows {
+		t.Errorf("getPushedItem for another user error = %v, want sql.ErrNoRows", err)
+	}
+
+	// 关键词损坏时仍返回推送记录
+	if _, err := db.Exec("UPDATE pushed_items SET keywords = 'not json' WHERE pushed_item_id = ?", id); err != nil {
+		t.Fatal(err)
+	}
+	got, err = getPushedItem(db, 100, id)
+	if err != nil || got.Keywords != nil || got.Link != item.Link {
+		t.Errorf("getPushedItem with corrupt keywords = %+v, %v, want item without keywords", got, err)
+	}
+}
+
+func TestEnqueueRecordedPush(t *testing.T) {
+	db, err := sql.Open("sqlite3", t.TempDir()+"/push.db?_foreign_keys=on")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := migrateSchema(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := execAll(db,
+		"INSERT INTO feeds (feed_id, rss_url, rss_name) VALUES (1, 'https://example.com/feed', '科技')",
+		"INSERT INTO users (user_id, created_at) VALUES (100, '2024-01-01 00:00:00')",
+	); err != nil {
+		t.Fatal(err)
+	}
+	// 不实际发送，消息留在发送队列中
+	messageSender = NewMessageSender(nil)
+	messageSender.queue.chat(100).running = true
+
+	entry := OutboxEntry{UserID: 100, SubscriptionID: 1, RSSName: "科技", Text: "标题"}
+	item := pushedItem{UserID: 100, FeedID: 1, FeedName: "科技", Title: "标题", Link: "https://example.com/1",
+		PublishedAt: "2024-01-01 00:00:00"}
+	if err := enqueueRecordedPush(db, entry, &item); err != nil {
+		t.Fatal(err)
+	}
+	var pushed int
+	var markup string
+	if err := db.QueryRow("SELECT COUNT(*) FROM pushed_items").Scan(&pushed); err != nil || pushed != 1 {
+		t.Fatalf("pushed_items = %d, %v, want 1", pushed, err)
+	}
+	if err := db.QueryRow("SELECT reply_markup FROM outbox").Scan(&markup); err != nil || markup == "" {
+		t.Fatalf("outbox reply_markup = %q, %v, want buttons", markup, err)
+	}
+
+	// 发件箱写入失败时推送记录一并回滚
+	if _, err := db.Exec("DROP TABLE outbox"); err != nil {
+		t.Fatal(err)
+	}
+	if err := enqueueRecordedPush(db, entry, &item); err == nil {
+		t.Fatal("enqueueRecordedPush succeeded without an outbox table")
+	}
+	if err := db.QueryRow("SELECT COUNT(*) FROM pushed_items").Scan(&pushed); err != nil || pushed != 1 {
+		t.Errorf("pushed_items after failed enqueue = %d, %v, want 1", pushed, err)
+	}
+}
@@ -0,0 +1,400 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// 推送方式
+const (
+	DeliveryInstant = "instant" // 命中后立即推送
+	DeliveryDigest  = "digest"  // 命中后汇总到摘要，按用户设置的时间统一推送
+
+	DefaultDigestSchedule = "09:00"               // 默认每天推送摘要的时间
+	MaxDigestInterval     = 168                   // 摘要推送间隔上限(小时)，即每周一次
+	DigestPageLength      = MaxMessageLength - 50 // 摘要每页的最大长度，预留页码的位置
+	MaxDigestTitleLength  = 200                   // 摘要中标题、订阅名称和关键词的最大字数
+)
+
+// deliveryNames 推送方式的显示名称
+var deliveryNames = map[string]string{
+	DeliveryInstant: "⚡ 即时推送",
+	DeliveryDigest:  "📬 摘要推送",
+}
+
+// digestIntervalRegex 按间隔推送摘要的写法，如 6h
+var digestIntervalRegex = regexp.MustCompile(`^(\d+)\s*[hH]$`)
+
+// digestMutex 保证同一时间只有一轮摘要推送
+var digestMutex sync.Mutex
+
+// digestSchedule 摘要推送时间，daily为每天固定时间推送，否则按间隔推送
+type digestSchedule struct {
+	daily  bool
+	minute int           // 每天推送的时间(当天第几分钟)
+	every  time.Duration // 推送间隔
+}
+
+// parseDigestSchedule 解析摘要推送时间，支持 HH:MM(每天) 和 Nh(每N小时)
+func parseDigestSchedule(text string) (digestSchedule, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		text = DefaultDigestSchedule
+	}
+	if m := digestIntervalRegex.FindStringSubmatch(text); m != nil {
+		hours, err := strconv.Atoi(m[1])
+		if err != nil || hours < 1 || hours > MaxDigestInterval {
+			return digestSchedule{}, fmt.Errorf("间隔需在 1-%d 小时之间", MaxDigestInterval)
+		}
+		return digestSchedule{every: time.Duration(hours) * time.Hour}, nil
+	}
+	at, err := time.Parse("15:04", text)
+	if err != nil {
+		return digestSchedule{}, fmt.Errorf("格式错误，请使用 HH:MM 或 Nh，如 21:30、6h")
+	}
+	return digestSchedule{daily: true, minute: at.Hour()*60 + at.Minute()}, nil
+}
+
+// String 返回摘要推送时间的说明
+func (s digestSchedule) String() string {
+	if s.daily {
+		return fmt.Sprintf("每天 %02d:%02d", s.minute/60, s.minute%60)
+	}
+	hours := int(s.every / time.Hour)
+	if hours == MaxDigestInterval {
+		return "每周一次"
+	}
+	return fmt.Sprintf("每 %d 小时", hours)
+}
+
+// due 检查自since之后是否到了推送时间，每天固定时间按用户时区计算
+func (s digestSchedule) due(now, since time.Time, location *time.Location) bool {
+	if !s.daily {
+		return !now.Before(since.Add(s.every))
+	}
+	local := now.In(location)
+	scheduled := time.Date(local.Year(), local.Month(), local.Day(), s.minute/60, s.minute%60, 0, 0, location)
+	if scheduled.After(local) {
+		scheduled = scheduled.AddDate(0, 0, -1)
+	}
+	return scheduled.After(since)
+}
+
+// addDigestItem 将命中的条目加入用户的待推送摘要
+func addDigestItem(db *sql.DB, userID int64, feedID int, msg Message, keywords []string) error {
+	keywordsJSON, err := json.Marshal(keywords)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC().Format("2006-01-02 15:04:05")
+	published := now
+	if !msg.PubDate.IsZero() {
+		published = msg.PubDate.UTC().Format("2006-01-02 15:04:05")
+	}
+	// 条目重新处理时可能再次命中，已在摘要中的链接不重复加入
+	_, err = db.Exec(`INSERT OR IGNORE INTO digest_items (user_id, feed_id, title, link, keywords, published_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`, userID, feedID, msg.Title, msg.Link, string(keywordsJSON), published, now)
+	return err
+}
+
+// setSubscriptionDelivery 修改用户订阅的推送方式
+func setSubscriptionDelivery(userID int64, feedID int, delivery string) error {
+	return withDB(func(db *sql.DB) error {
+		_, err := db.Exec("UPDATE user_subscriptions SET delivery = ? WHERE user_id = ? AND feed_id = ?", delivery, userID, feedID)
+		return err
+	})
+}
+
+// switchSubscriptionDelivery 切换订阅的推送方式
+func (h *UserActionHandler) switchSubscriptionDelivery(userID int64, messageID int, subscriptionID int, delivery string) {
+	if delivery != DeliveryInstant && delivery != DeliveryDigest {
+		h.sender.SendError(userID, messageID, "未知的推送方式")
+		return
+	}
+	if _, err := findSubscriptionForUser(userID, subscriptionID); err != nil {
+		h.sender.SendError(userID, messageID, "未找到该订阅，可能已被删除")
+		return
+	}
+	if err := setSubscriptionDelivery(userID, subscriptionID, delivery); err != nil {
+		logMessage("error", fmt.Sprintf("修改订阅推送方式失败: %v", err), userID)
+		h.sender.SendError(userID, messageID, "修改推送方式失败，请稍后重试")
+		return
+	}
+	h.showSubscriptionDetail(userID, messageID, subscriptionID)
+}
+
+// getDigestSchedule 获取用户的摘要推送时间
+func getDigestSchedule(userID int64) (digestSchedule, error) {
+	var text string
+	err := withDB(func(db *sql.DB) error {
+		return db.QueryRow("SELECT digest_schedule FROM users WHERE user_id = ?", userID).Scan(&text)
+	})
+	if err != nil && err != sql.ErrNoRows {
+		return digestSchedule{}, err
+	}
+	return parseDigestSchedule(text)
+}
+
+// setDigestSchedule 保存用户的摘要推送时间
+func setDigestSchedule(userID int64, schedule string) error {
+	return withDB(func(db *sql.DB) error {
+		if err := ensureUser(db, userID); err != nil {
+			return err
+		}
+		_, err := db.Exec("UPDATE users SET digest_schedule = ? WHERE user_id = ?", schedule, userID)
+		return err
+	})
+}
+
+// sendDueDigests 推送所有到期的摘要，每轮调度时调用
+func sendDueDigests(db *sql.DB) {
+	if !digestMutex.TryLock() {
+		return
+	}
+	defer digestMutex.Unlock()
+
+	rows, err := db.Query(`SELECT d.user_id, MIN(d.created_at), COALESCE(u.digest_schedule, ''), COALESCE(u.digest_sent_at, '')
+		FROM digest_items d LEFT JOIN users u ON u.user_id = d.user_id GROUP BY d.user_id`)
+	if err != nil {
+		logMessage("error", fmt.Sprintf("读取待推送摘要失败: %v", err))
+		return
+	}
+
+	now := time.Now()
+	var dueUsers []int64
+	for rows.Next() {
+		var userID int64
+		var oldest, scheduleText, sentAt string
+		if err := rows.Scan(&userID, &oldest, &scheduleText, &sentAt); err != nil {
+			continue
+		}
+		schedule, err := parseDigestSchedule(scheduleText)
+		if err != nil {
+			schedule, _ = parseDigestSchedule(DefaultDigestSchedule)
+		}
+
+		// 从上次推送或最早一条待推送内容开始计算，取较晚者
+		since, _ := time.Parse("2006-01-02 15:04:05", oldest)
+		if sent, err := time.Parse("2006-01-02 15:04:05", sentAt); err == nil && sent.After(since) {
+			since = sent
+		}
+		location := defaultLocation
+		if prefs, err := getUserPrefs(db, userID); err == nil {
+			location = prefs.location
+		}
+		if schedule.due(now, since, location) {
+			dueUsers = append(dueUsers, userID)
+		}
+	}
+	rows.Close()
+
+	for _, userID := range dueUsers {
+		if err := sendDigest(db, userID); err != nil {
+			logMessage("error", fmt.Sprintf("推送摘要失败: %v", err), userID)
+		}
+	}
+}
+
+// digestItem 摘要中的一条内容
+type digestItem struct {
+	feedName  string // 用户设置的订阅名称
+	rssName   string // 订阅源名称，用于推送统计
+	title     string
+	link      string
+	keywords  string
+	published time.Time
+}
+
+// sendDigest 将用户的待推送摘要写入发件箱并清空
+func sendDigest(db *sql.DB, userID int64) error {
+	prefs, err := getUserPrefs(db, userID)
+	if err != nil {
+		prefs = defaultUserPrefs()
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT d.digest_item_id, COALESCE(us.alias, f.rss_name), f.rss_name, d.title, d.link, d.keywords, d.published_at
+		FROM digest_items d JOIN feeds f ON f.feed_id = d.feed_id
+		LEFT JOIN user_subscriptions us ON us.user_id = d.user_id AND us.feed_id = d.feed_id
+		WHERE d.user_id = ? ORDER BY d.digest_item_id`, userID)
+	if err != nil {
+		return err
+	}
+	var items []digestItem
+	var maxID int64
+	for rows.Next() {
+		var item digestItem
+		var id int64
+		var keywordsJSON, published string
+		if err := rows.Scan(&id, &item.feedName, &item.rssName, &item.title, &item.link, &keywordsJSON, &published); err != nil {
+			rows.Close()
+			return err
+		}
+		var keywords []string
+		json.Unmarshal([]byte(keywordsJSON), &keywords)
+		item.keywords = strings.Join(keywords, ", ")
+		item.published, _ = time.Parse("2006-01-02 15:04:05", published)
+		items = append(items, item)
+		if id > maxID {
+			maxID = id
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(items) == 0 {
+		return nil
+	}
+
+	// 每条内容占一行，按行分页不会截断标签；分页写入发件箱与删除摘要条目在同一事务中完成
+	pages := splitMessage(formatDigest(items, prefs.location), DigestPageLength)
+	for i, page := range pages {
+		if len(pages) > 1 {
+			page = fmt.Sprintf("%s\n\n📄 %d/%d", page, i+1, len(pages))
+		}
+		pages[i] = ensureDigestPage(page, userID)
+	}
+
+	now := time.Now().UTC()
+	var entries []OutboxEntry
+	var held bool
+	for _, page := range pages {
+		entry := OutboxEntry{UserID: userID, RSSName: "摘要", Text: page}
+		nextAttempt := prefs.applyQuietHours(&entry, now)
+		result, err := insertOutboxEntry(tx, entry, now, nextAttempt)
+		if err != nil {
+			return err
+		}
+		entry.ID, _ = result.LastInsertId()
+		entries = append(entries, entry)
+		held = nextAttempt.After(now)
+	}
+	if _, err := tx.Exec("DELETE FROM digest_items WHERE user_id = ? AND digest_item_id <= ?", userID, maxID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE users SET digest_sent_at = ? WHERE user_id = ?", now.Format("2006-01-02 15:04:05"), userID); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	for _, item := range items {
+		recordPush(item.rssName)
+	}
+
+	// 免打扰暂存的摘要由发件箱在时段结束后投递
+	if !held {
+		for _, entry := range entries {
+			dispatchOutboxEntry(db, entry)
+		}
+	}
+	logMessage("info", fmt.Sprintf("已推送摘要，共 %d 条内容 %d 页", len(items), len(pages)), userID)
+	return nil
+}
+
+// ensureDigestPage 校验摘要分页，不是有效的Telegram HTML时改为纯文本发送
+// 避免Telegram拒收导致摘要丢失，也避免保留条目后每轮重试都失败
+func ensureDigestPage(page string, userID int64) string {
+	err := validateTelegramHTML(page)
+	if err == nil && utf8.ValidString(page) {
+		return page
+	}
+	logMessage("warn", fmt.Sprintf("摘要分页格式无效，改为纯文本发送: %v", err), userID)
+	return html.EscapeString(strings.ToValidUTF8(plainText(page), ""))
+}
+
+// formatDigest 按订阅和命中关键词分组生成摘要内容，每条内容单独一行且不超过一页
+func formatDigest(items []digestItem, location *time.Location) string {
+	var feeds []string
+	groups := make(map[string]map[string][]digestItem)
+	var keywordOrder = make(map[string][]string)
+	for _, item := range items {
+		if groups[item.feedName] == nil {
+			groups[item.feedName] = make(map[string][]digestItem)
+			feeds = append(feeds, item.feedName)
+		}
+		if groups[item.feedName][item.keywords] == nil {
+			keywordOrder[item.feedName] = append(keywordOrder[item.feedName], item.keywords)
+		}
+		groups[item.feedName][item.keywords] = append(groups[item.feedName][item.keywords], item)
+	}
+
+	lines := []string{fmt.Sprintf("📬 订阅摘要：共 %d 条", len(items))}
+	for _, feed := range feeds {
+		count := 0
+		for _, group := range groups[feed] {
+			count += len(group)
+		}
+		lines = append(lines, "", fmt.Sprintf("📰 <b>%s</b>（%d 条）", html.EscapeString(digestLine(feed)), count))
+		for _, keywords := range keywordOrder[feed] {
+			lines = append(lines, fmt.Sprintf("🔖 <code>%s</code>", html.EscapeString(digestLine(keywords))))
+			for _, item := range groups[feed][keywords] {
+				title := html.EscapeString(digestLine(item.title))
+				published := item.published.In(location).Format("01-02 15:04")
+				line := fmt.Sprintf("• %s  %s", title, published)
+				if item.link != "" {
+					// 链接过长时只保留标题，避免单行超过一页被截断
+					linked := fmt.Sprintf(`• <a href="%s">%s</a>  %s`, html.EscapeString(item.link), title, published)
+					if len(linked) <= DigestPageLength {
+						line = linked
+					}
+				}
+				lines = append(lines, line)
+			}
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// digestLine 将文本合并为一行并限制长度，保证摘要按行分页
+func digestLine(text string) string {
+	runes := []rune(strings.Join(strings.Fields(text), " "))
+	if len(runes) > MaxDigestTitleLength {
+		return string(runes[:MaxDigestTitleLength]) + "…"
+	}
+	return string(runes)
+}
+
+// handleDigestCommand 处理 /digest 命令，查看或设置摘要推送时间
+func handleDigestCommand(userID int64, args string) {
+	args = strings.TrimSpace(args)
+	keyboard := CreateBackButton()
+	if args == "" {
+		schedule, err := getDigestSchedule(userID)
+		if err != nil {
+			logMessage("error", fmt.Sprintf("获取摘要设置失败: %v", err), userID)
+			messageSender.SendError(userID, 0, "获取摘要设置失败，请稍后重试")
+			return
+		}
+		messageSender.SendResponse(userID, 0, fmt.Sprintf("📬 摘要推送时间：%s\n\n"+
+			"设置方法：\n/digest 21:30  每天21:30推送\n/digest 6h  每6小时推送\n/digest %dh  每周推送一次\n\n"+
+			"💡 在 查看订阅 中点击订阅，可将该订阅切换为摘要推送", schedule, MaxDigestInterval), &keyboard)
+		return
+	}
+
+	schedule, err := parseDigestSchedule(args)
+	if err != nil {
+		messageSender.SendError(userID, 0, "❌ "+err.Error())
+		return
+	}
+	if err := setDigestSchedule(userID, args); err != nil {
+		logMessage("error", fmt.Sprintf("保存摘要设置失败: %v", err), userID)
+		messageSender.SendError(userID, 0, "保存摘要设置失败，请稍后重试")
+		return
+	}
+	messageSender.SendResponse(userID, 0, fmt.Sprintf("✅ 摘要推送时间已设置为：%s", schedule), &keyboard)
+}
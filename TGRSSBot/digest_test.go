@@ -0,0 +1,284 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestSplitMessage(t *testing.T) {
+	long := strings.Repeat("显卡", 10) // 60字节
+	tests := []struct {
+		text      string
+		maxLength int
+		want      []string
+	}{
+		{"short", 10, []string{"short"}},
+		{"aaaa\nbbbb\ncccc", 9, []string{"aaaa\nbbbb", "cccc"}},
+		{"aaaa\n\nbbbb", 5, []string{"aaaa", "bbbb"}},
+		{long, 30, []string{strings.Repeat("显卡", 5), strings.Repeat("显卡", 5)}},
+		{"a" + long, 32, []string{"a" + strings.Repeat("显卡", 5), strings.Repeat("显卡", 5)}},
+		{"", 10, nil},
+	}
+	for _, tt := range tests {
+		got := splitMessage(tt.text, tt.maxLength)
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") || len(got) != len(tt.want) {
+			t.Errorf("splitMessage(%q, %d) = %q, want %q", tt.text, tt.maxLength, got, tt.want)
+		}
+		for _, chunk := range got {
+			if len(chunk) > tt.maxLength || !utf8.ValidString(chunk) {
+				t.Errorf("splitMessage(%q, %d) produced invalid chunk %q", tt.text, tt.maxLength, chunk)
+			}
+		}
+	}
+}
+
+func TestFormatDigestPages(t *testing.T) {
+	var items []digestItem
+	for i := 0; i < 300; i++ {
+		items = append(items, digestItem{
+			feedName:  fmt.Sprintf("订阅<%d>", i%3),
+			title:     fmt.Sprintf("第 %d 条 RTX 4090 显卡 & 主机\n特价", i),
+			link:      fmt.Sprintf("https://example.com/post?id=%d&from=rss", i),
+			keywords:  "显卡, 4090",
+			published: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+		})
+	}
+	items = append(items, digestItem{feedName: "长标题", title: strings.Repeat("长", 5000), link: "https://example.com/" + strings.Repeat("x", 5000)})
+
+	pages := splitMessage(formatDigest(items, time.UTC), DigestPageLength)
+	if len(pages) < 2 {
+		t.Fatalf("got %d pages, want several", len(pages))
+	}
+	count := 0
+	for i, page := range pages {
+		if len(page) > DigestPageLength {
+			t.Errorf("page %d is %d bytes, want <= %d", i+1, len(page), DigestPageLength)
+		}
+		if err := validateTelegramHTML(page); err != nil {
+			t.Errorf("page %d is not valid Telegram HTML: %v", i+1, err)
+		}
+		count += strings.Count("\n"+page, "\n• ")
+	}
+	if count != len(items) {
+		t.Errorf("pages contain %d items, want %d", count, len(items))
+	}
+}
+
+func TestParseDigestSchedule(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+		ok   bool
+	}{
+		{"", "每天 09:00", true},
+		{"21:30", "每天 21:30", true},
+		{" 7:05 ", "每天 07:05", true},
+		{"6h", "每 6 小时", true},
+		{"6 H", "每 6 小时", true},
+		{"168h", "每周一次", true},
+		{"0h", "", false},
+		{"169h", "", false},
+		{"25:00", "", false},
+		{"每天", "", false},
+	}
+	for _, tt := range tests {
+		schedule, err := parseDigestSchedule(tt.text)
+		if (err == nil) != tt.ok {
+			t.Errorf("parseDigestSchedule(%q) error = %v, want ok %v", tt.text, err, tt.ok)
+			continue
+		}
+		if tt.ok && schedule.String() != tt.want {
+			t.Errorf("parseDigestSchedule(%q) = %s, want %s", tt.text, schedule, tt.want)
+		}
+	}
+}
+
+func TestDigestScheduleDue(t *testing.T) {
+	shanghai := time.FixedZone("UTC+08:00", 8*3600)
+	newYork := time.FixedZone("UTC-05:00", -5*3600)
+	kiritimati := time.FixedZone("UTC+14:00", 14*3600)
+	utc := func(day, hour, minute int) time.Time { return time.Date(2024, 1, day, hour, minute, 0, 0, time.UTC) }
+
+	daily, _ := parseDigestSchedule("09:00")
+	every, _ := parseDigestSchedule("6h")
+	tests := []struct {
+		name     string
+		schedule digestSchedule
+		now      time.Time
+		since    time.Time
+		location *time.Location
+		want     bool
+	}{
+		// 上海 09:30，上次推送在前一天 10:00
+		{"shanghai after schedule", daily, utc(2, 1, 30), utc(1, 2, 0), shanghai, true},
+		// 上海 08:30，今天的推送时间还没到
+		{"shanghai before schedule", daily, utc(2, 0, 30), utc(1, 2, 0), shanghai, false},
+		// 同一时刻在UTC时区是 00:30，前一天 09:00 已经推送过
+		{"utc same instant", daily, utc(2, 0, 30), utc(1, 9, 30), time.UTC, false},
+		// 纽约 09:30，UTC已经是当天下午
+		{"new york after schedule", daily, utc(2, 14, 30), utc(1, 15, 0), newYork, true},
+		{"new york before schedule", daily, utc(2, 13, 30), utc(1, 15, 0), newYork, false},
+		// UTC+14 当地已是第二天 09:30，UTC日期仍是前一天
+		{"kiritimati ahead of utc date", daily, utc(1, 19, 30), utc(1, 0, 0), kiritimati, true},
+		// 当天推送过后不再重复推送
+		{"already sent today", daily, utc(2, 3, 0), utc(2, 1, 5), shanghai, false},
+		{"interval not reached", every, utc(1, 5, 59), utc(1, 0, 0), shanghai, false},
+		{"interval reached", every, utc(1, 6, 0), utc(1, 0, 0), newYork, true},
+	}
+	for _, tt := range tests {
+		if got := tt.schedule.due(tt.now, tt.since, tt.location); got != tt.want {
+			t.Errorf("%s: due(%v, %v) = %v, want %v", tt.name, tt.now, tt.since, got, tt.want)
+		}
+	}
+}
+
+func TestSendDigest(t *testing.T) {
+	db, err := sql.Open("sqlite3", t.TempDir()+"/digest.db?_foreign_keys=on")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := migrateSchema(db); err != nil {
+		t.Fatal(err)
+	}
+
+	const userID = 100
+	// 不实际发送，消息留在发送队列中
+	messageSender = NewMessageSender(nil)
+	messageSender.queue.chat(userID).running = true
+
+	if err := execAll(db,
+		"INSERT INTO feeds (feed_id, rss_url, rss_name) VALUES (1, 'https://example.com/feed', '科技')",
+		"INSERT INTO users (user_id, created_at) VALUES (100, '2024-01-01 00:00:00')",
+		"INSERT INTO user_subscriptions (user_id, feed_id, alias, created_at) VALUES (100, 1, '科技', '2024-01-01 00:00:00')",
+	); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 200; i++ {
+		msg := Message{Title: fmt.Sprintf("显卡 %d <特价> & 包邮", i), Link: fmt.Sprintf("https://example.com/%d?a=1&b=2", i)}
+		if err := addDigestItem(db, userID, 1, msg, []string{"显卡"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	DailyPushStats.mutex.Lock()
+	before := DailyPushStats.ByRSS["科技"]
+	DailyPushStats.mutex.Unlock()
+
+	if err := sendDigest(db, userID); err != nil {
+		t.Fatalf("sendDigest: %v", err)
+	}
+
+	// 摘要发出时才计入推送统计
+	DailyPushStats.mutex.Lock()
+	pushed := DailyPushStats.ByRSS["科技"] - before
+	DailyPushStats.mutex.Unlock()
+	if pushed != 200 {
+		t.Errorf("push stats counted %d digest items, want 200", pushed)
+	}
+
+	rows, err := db.Query("SELECT text FROM outbox WHERE user_id = ? ORDER BY outbox_id", userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pages := scanStrings(t, rows)
+	if len(pages) < 2 {
+		t.Fatalf("got %d outbox pages, want several", len(pages))
+	}
+	for i, page := range pages {
+		if err := validateTelegramHTML(page); err != nil {
+			t.Errorf("page %d is not valid Telegram HTML: %v", i+1, err)
+		}
+		if !strings.HasSuffix(page, fmt.Sprintf("📄 %d/%d", i+1, len(pages))) {
+			t.Errorf("page %d missing page number", i+1)
+		}
+	}
+
+	var remaining int
+	if err := db.QueryRow("SELECT COUNT(*) FROM digest_items WHERE user_id = ?", userID).Scan(&remaining); err != nil || remaining != 0 {
+		t.Errorf("digest_items remaining = %d, %v, want 0", remaining, err)
+	}
+}
+
+func TestAddDigestItemUnique(t *testing.T) {
+	db, err := sql.Open("sqlite3", t.TempDir()+"/digest.db?_foreign_keys=on")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := migrateSchema(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := execAll(db,
+		"INSERT INTO feeds (feed_id, rss_url, rss_name) VALUES (1, 'https://example.com/feed', '科技')",
+		"INSERT INTO users (user_id, created_at) VALUES (100, '2024-01-01 00:00:00')",
+	); err != nil {
+		t.Fatal(err)
+	}
+	count := func() int {
+		var n int
+		if err := db.QueryRow("SELECT COUNT(*) FROM digest_items").Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	// 重新处理的同一条目不会重复加入摘要，没有链接的条目不去重
+	for i := 0; i < 2; i++ {
+		for _, msg := range []Message{{Title: "显卡", Link: "https://example.com/1"}, {Title: "无链接"}} {
+			if err := addDigestItem(db, 100, 1, msg, []string{"显卡"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if n := count(); n != 3 {
+		t.Errorf("digest_items = %d, want 3", n)
+	}
+
+	// 升级时清理已有的重复条目
+	if err := execAll(db,
+		"DROP INDEX idx_digest_items_unique",
+		`INSERT INTO digest_items (user_id, feed_id, title, link, published_at, created_at)
+			VALUES (100, 1, '显卡', 'https://example.com/1', '', '')`,
+	); err != nil {
+		t.Fatal(err)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := migrateDigestUnique(tx); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if n := count(); n != 3 {
+		t.Errorf("digest_items after migration = %d, want 3", n)
+	}
+}
+
+func TestEnsureDigestPage(t *testing.T) {
+	tests := []struct {
+		page string
+		want string
+	}{
+		{"📬 <b>摘要</b> &amp; 更多", "📬 <b>摘要</b> &amp; 更多"},
+		{"📬 <b>摘要 & 更多", "📬 摘要 &amp; 更多"},
+		{"<a href=\"https://example.com\">链接</b>", "链接"},
+		{"显卡\xff<i>特价</i>", "显卡特价"},
+	}
+	for _, tt := range tests {
+		got := ensureDigestPage(tt.page, 100)
+		if got != tt.want {
+			t.Errorf("ensureDigestPage(%q) = %q, want %q", tt.page, got, tt.want)
+		}
+		if err := validateTelegramHTML(got); err != nil || !utf8.ValidString(got) {
+			t.Errorf("ensureDigestPage(%q) = %q is not valid: %v", tt.page, got, err)
+		}
+	}
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// 订阅过滤模式
+const (
+	FilterInherit = "inherit" // 使用全局关键词
+	FilterAll     = "all"     // 全部推送，全局屏蔽词仍然生效
+	FilterCustom  = "custom"  // 使用该订阅的专属关键词，全局屏蔽词仍然生效
+)
+
+// subscriptionFilter 用户对单个订阅的过滤设置
+type subscriptionFilter struct {
+	Mode     string
+	Keywords []string
+	rules    []*keywordRule // 编译后的专属关键词
+}
+
+// matchAllNode 匹配任何内容，用于全部推送模式
+type matchAllNode struct{}
+
+func (matchAllNode) eval(matchText) bool { return true }
+
+// matchAllRule 全部推送模式下的匹配规则
+var matchAllRule = &keywordRule{Raw: "*", Keyword: "全部推送", Scope: "default", expr: matchAllNode{}}
+
+// filterModeNames 过滤模式的显示名称
+var filterModeNames = map[string]string{
+	FilterInherit: "🔗 继承全局关键词",
+	FilterAll:     "📢 全部推送",
+	FilterCustom:  "🎯 专属关键词",
+}
+
+// loadSubscriptionFilters 从数据库读取用户的全部订阅过滤设置
+func loadSubscriptionFilters(db *sql.DB, userID int64) (map[int]*subscriptionFilter, error) {
+	rows, err := db.Query("SELECT subscription_id, mode, keywords FROM subscription_filters WHERE user_id = ?", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	filters := make(map[int]*subscriptionFilter)
+	for rows.Next() {
+		var subscriptionID int
+		var filter subscriptionFilter
+		var keywordsStr string
+		if err := rows.Scan(&subscriptionID, &filter.Mode, &keywordsStr); err != nil {
+			continue
+		}
+		filter.Keywords = parseKeywords(keywordsStr)
+		filters[subscriptionID] = &filter
+	}
+	return filters, rows.Err()
+}
+
+// getSubscriptionFilter 获取用户对订阅的过滤设置，没有设置时返回继承模式
+func getSubscriptionFilter(userID int64, subscriptionID int) (*subscriptionFilter, error) {
+	filter := &subscriptionFilter{Mode: FilterInherit}
+	var keywordsStr string
+	err := withDB(func(db *sql.DB) error {
+		return db.QueryRow("SELECT mode, keywords FROM subscription_filters WHERE user_id = ? AND subscription_id = ?",
+			userID, subscriptionID).Scan(&filter.Mode, &keywordsStr)
+	})
+	if err == sql.ErrNoRows {
+		return filter, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	filter.Keywords = parseKeywords(keywordsStr)
+	return filter, nil
+}
+
+// setSubscriptionFilterMode 修改订阅的过滤模式
+func setSubscriptionFilterMode(userID int64, subscriptionID int, mode string) error {
+	err := withDB(func(db *sql.DB) error {
+		_, err := db.Exec(`INSERT INTO subscription_filters (user_id, subscription_id, mode) VALUES (?, ?, ?)
+			ON CONFLICT(user_id, subscription_id) DO UPDATE SET mode = excluded.mode`,
+			userID, subscriptionID, mode)
+		return err
+	})
+	if err == nil {
+		invalidateUserMatcher(userID)
+	}
+	return err
+}
+
+// setSubscriptionKeywords 保存订阅的专属关键词并切换到专属关键词模式
+func setSubscriptionKeywords(userID int64, subscriptionID int, keywords []string) error {
+	keywordsJSON, err := json.Marshal(keywords)
+	if err != nil {
+		return err
+	}
+	if keywords == nil {
+		keywordsJSON = []byte("[]")
+	}
+
+	err = withDB(func(db *sql.DB) error {
+		_, err := db.Exec(`INSERT INTO subscription_filters (user_id, subscription_id, mode, keywords) VALUES (?, ?, ?, ?)
+			ON CONFLICT(user_id, subscription_id) DO UPDATE SET mode = excluded.mode, keywords = excluded.keywords`,
+			userID, subscriptionID, FilterCustom, string(keywordsJSON))
+		return err
+	})
+	if err == nil {
+		invalidateUserMatcher(userID)
+	}
+	return err
+}
+
+// findSubscriptionForUser 查找用户订阅中指定ID的订阅
+func findSubscriptionForUser(userID int64, subscriptionID int) (*SubscriptionInfo, error) {
+	subscriptions, err := getSubscriptionsForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, sub := range subscriptions {
+		if sub.ID == subscriptionID {
+			return &sub, nil
+		}
+	}
+	return nil, fmt.Errorf("未找到订阅 %d", subscriptionID)
+}
+
+// blockRules 返回全局关键词中的屏蔽词
+func (m *userMatcher) blockRules() []*keywordRule {
+	var rules []*keywordRule
+	for _, rule := range m.rules {
+		if rule.Block {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// rulesFor 返回订阅实际使用的匹配规则，为空表示该订阅不推送
+func (m *userMatcher) rulesFor(subscriptionID int) []*keywordRule {
+	filter, ok := m.filters[subscriptionID]
+	if !ok {
+		return m.rules
+	}
+
+	switch filter.Mode {
+	case FilterAll:
+		return append([]*keywordRule{matchAllRule}, m.blockRules()...)
+	case FilterCustom:
+		if len(filter.rules) == 0 {
+			return nil
+		}
+		return append(append([]*keywordRule{}, filter.rules...), m.blockRules()...)
+	default:
+		return m.rules
+	}
+}
+
+// parseSubscriptionID 解析回调数据中的订阅ID
+func parseSubscriptionID(value string) (int, bool) {
+	id, err := strconv.Atoi(value)
+	return id, err == nil
+}
+
+// showSubscriptionDetail 显示订阅详情和过滤设置
+func (h *UserActionHandler) showSubscriptionDetail(userID int64, messageID int, subscriptionID int) {
+	sub, err := findSubscriptionForUser(userID, subscriptionID)
+	if err != nil {
+		h.sender.SendError(userID, messageID, "未找到该订阅，可能已被删除")
+		return
+	}
+	filter, err := getSubscriptionFilter(userID, subscriptionID)
+	if err != nil {
+		logMessage("error", fmt.Sprintf("获取订阅过滤设置失败: %v", err), userID)
+		h.sender.SendError(userID, messageID, "获取订阅设置失败，请稍后重试")
+		return
+	}
+
+	text := fmt.Sprintf("📰 <b>%s</b>\n🔗 %s\n%s\n\n🔍 过滤模式：%s",
+		html.EscapeString(sub.Name), html.EscapeString(sub.URL), formatSubscriptionHealth(*sub, userLocation(userID)), filterModeNames[filter.Mode])
+	switch filter.Mode {
+	case FilterAll:
+		text += "\n推送该订阅的全部内容，全局屏蔽词仍然生效"
+	case FilterCustom:
+		if len(filter.Keywords) == 0 {
+			text += "\n还没有专属关键词，该订阅暂不推送"
+		} else {
+			text += "\n全局屏蔽词仍然生效，专属关键词：\n" + h.formatKeywordsList(filter.Keywords)
+		}
+	default:
+		text += "\n使用你的全局关键词匹配该订阅"
+	}
+
+	// 推送方式，按钮切换到另一种方式
+	text += "\n\n📮 推送方式：" + deliveryNames[sub.Delivery]
+	nextDelivery := DeliveryDigest
+	if sub.Delivery == DeliveryDigest {
+		nextDelivery = DeliveryInstant
+		text += "\n命中的内容将汇总后按 /digest 设置的时间推送"
+	}
+
+	if sub.Template == "" {
+		text += "\n🎨 推送模板：默认"
+	} else {
+		text += "\n🎨 推送模板：自定义，可用 /preview " + html.EscapeString(sub.Name) + " 预览"
+	}
+
+	id := strconv.Itoa(subscriptionID)
+	deliveryRow := tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("切换为"+deliveryNames[nextDelivery], "sub_delivery_"+id+"_"+nextDelivery),
+	)
+	// 通过推送按钮屏蔽的订阅，显示截止时间和恢复按钮
+	if mutedUntil, err := time.Parse("2006-01-02 15:04:05", sub.MutedUntil); err == nil && mutedUntil.After(time.Now()) {
+		text += "\n🔇 已屏蔽至 " + mutedUntil.In(userLocation(userID)).Format("2006-01-02 15:04")
+		deliveryRow = append(deliveryRow, tgbotapi.NewInlineKeyboardButtonData("🔔 恢复推送", "sub_unmute_"+id))
+	}
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(filterModeNames[FilterInherit], "sub_filter_"+id+"_"+FilterInherit),
+			tgbotapi.NewInlineKeyboardButtonData(filterModeNames[FilterAll], "sub_filter_"+id+"_"+FilterAll),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("✏️ 设置专属关键词", "sub_keywords_"+id),
+			tgbotapi.NewInlineKeyboardButtonData("🎨 设置推送模板", "sub_template_"+id),
+		),
+		deliveryRow,
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("📰 返回订阅列表", "view_subscriptions"),
+			tgbotapi.NewInlineKeyboardButtonData("🔙 返回主菜单", "back_to_menu"),
+		),
+	)
+	h.sender.SendHTMLResponse(userID, messageID, text, &keyboard, true)
+}
+
+// setSubscriptionFilter 切换订阅的过滤模式
+func (h *UserActionHandler) setSubscriptionFilter(userID int64, messageID int, subscriptionID int, mode string) {
+	if mode != FilterInherit && mode != FilterAll && mode != FilterCustom {
+		h.sender.SendError(userID, messageID, "未知的过滤模式")
+		return
+	}
+	if _, err := findSubscriptionForUser(userID, subscriptionID); err != nil {
+		h.sender.SendError(userID, messageID, "未找到该订阅，可能已被删除")
+		return
+	}
+	if err := setSubscriptionFilterMode(userID, subscriptionID, mode); err != nil {
+		logMessage("error", fmt.Sprintf("修改订阅过滤模式失败: %v", err), userID)
+		h.sender.SendError(userID, messageID, "修改过滤模式失败，请稍后重试")
+		return
+	}
+	h.showSubscriptionDetail(userID, messageID, subscriptionID)
+}
+
+// promptSubscriptionKeywords 提示输入订阅的专属关键词
+func (h *UserActionHandler) promptSubscriptionKeywords(userID int64, messageID int, subscriptionID int) {
+	sub, err := findSubscriptionForUser(userID, subscriptionID)
+	if err != nil {
+		h.sender.SendError(userID, messageID, "未找到该订阅，可能已被删除")
+		return
+	}
+
+	setUserState(userID, "set_sub_keywords", messageID, map[string]interface{}{"subscription_id": subscriptionID})
+	text := fmt.Sprintf("请输入订阅 \"%s\" 的专属关键词，将替换该订阅原有的专属关键词：\n\n"+
+		"💡 写法与全局关键词相同，无需再加 +RSS名称\n💡 全局关键词中的屏蔽词对该订阅仍然生效\n💡 发送 清空 可删除全部专属关键词", sub.Name)
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🔙 返回订阅详情", fmt.Sprintf("sub_detail_%d", subscriptionID)),
+		),
+	)
+	h.sender.SendResponse(userID, messageID, text, &keyboard)
+}
+
+// saveSubscriptionKeywords 校验并保存订阅的专属关键词
+func (h *UserActionHandler) saveSubscriptionKeywords(userID int64, subscriptionID int, keywords []string) {
+	if _, err := findSubscriptionForUser(userID, subscriptionID); err != nil {
+		clearUserState(userID)
+		h.sender.SendError(userID, 0, "未找到该订阅，可能已被删除")
+		return
+	}
+
+	if len(keywords) == 1 && keywords[0] == "清空" {
+		keywords = nil
+	}
+	if problems := validateKeywords(keywords); len(problems) > 0 {
+		h.sender.SendError(userID, 0, fmt.Sprintf("❌ 关键词格式错误，本次未保存：\n%s", strings.Join(problems, "\n")))
+		return
+	}
+
+	if err := setSubscriptionKeywords(userID, subscriptionID, keywords); err != nil {
+		logMessage("error", fmt.Sprintf("保存订阅专属关键词失败: %v", err), userID)
+		h.sender.SendError(userID, 0, "保存专属关键词失败，请稍后重试")
+		return
+	}
+	clearUserState(userID)
+	h.showSubscriptionDetail(userID, 0, subscriptionID)
+}
+
+// createSubscriptionListKeyboard 创建订阅列表键盘，点击订阅进入详情
+func createSubscriptionListKeyboard(subscriptions []SubscriptionInfo) tgbotapi.InlineKeyboardMarkup {
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, sub := range subscriptions {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("⚙️ "+sub.Name, fmt.Sprintf("sub_detail_%d", sub.ID)),
+		))
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("🔙 返回主菜单", "back_to_menu"),
+	))
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+// createDeleteSubscriptionKeyboard 创建删除订阅键盘，回调数据使用订阅ID
+func createDeleteSubscriptionKeyboard(subscriptions []SubscriptionInfo) tgbotapi.InlineKeyboardMarkup {
+	const buttonsPerRow = 3
+	var rows [][]tgbotapi.InlineKeyboardButton
+	var currentRow []tgbotapi.InlineKeyboardButton
+	for i, sub := range subscriptions {
+		currentRow = append(currentRow, tgbotapi.NewInlineKeyboardButtonData("❌ "+sub.Name, fmt.Sprintf("del_sub_%d", sub.ID)))
+		if len(currentRow) == buttonsPerRow || i == len(subscriptions)-1 {
+			rows = append(rows, currentRow)
+			currentRow = nil
+		}
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("🔙 返回主菜单", "back_to_menu"),
+	))
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// 搜索相关常量
+const (
+	SearchPageSize    = 5     // 搜索结果每页条数
+	KeywordTestPrefix = "kw:" // 关键词测试前缀，/search kw:关键词 检查新关键词在历史条目中的命中情况
+	KeywordTestLimit  = 1000  // 关键词测试最多检查的历史条目数
+	KeywordTestShow   = 10    // 关键词测试最多列出的命中条目数
+)
+
+var (
+	historyFTS     bool                     // SQLite是否支持FTS5全文索引
+	lastSearches   = make(map[int64]string) // 用户最近一次搜索的内容，用于翻页
+	lastSearchLock sync.Mutex
+)
+
+// historyEntry 抓取到的条目，抓取状态保存时写入历史记录
+type historyEntry struct {
+	key string
+	msg Message
+}
+
+// historyItem 历史记录中的一条结果
+type historyItem struct {
+	FeedName  string
+	Title     string
+	Link      string
+	Published time.Time
+}
+
+// ensureHistorySearch 为条目历史启用FTS5全文索引
+// 需要使用 -tags sqlite_fts5 编译，不支持时删除同步触发器并退回LIKE查询，之后启用时会重建索引
+func ensureHistorySearch(db *sql.DB) error {
+	var enabled bool
+	if err := db.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&enabled); err != nil {
+		return err
+	}
+	historyFTS = false
+	if !enabled {
+		logMessage("info", "SQLite未启用FTS5，历史搜索使用LIKE查询")
+		return execAll(db,
+			"DROP TRIGGER IF EXISTS item_history_ai",
+			"DROP TRIGGER IF EXISTS item_history_ad",
+		)
+	}
+
+	var triggers int
+	err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name IN ('item_history_ai', 'item_history_ad')").Scan(&triggers)
+	if err != nil {
+		return err
+	}
+
+	err = execAll(db,
+		`CREATE VIRTUAL TABLE IF NOT EXISTS item_history_fts USING fts5(
+			title, description, content='item_history', content_rowid='item_id', tokenize='trigram'
+		)`,
+		`CREATE TRIGGER IF NOT EXISTS item_history_ai AFTER INSERT ON item_history BEGIN
+			INSERT INTO item_history_fts (rowid, title, description) VALUES (new.item_id, new.title, new.description);
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS item_history_ad AFTER DELETE ON item_history BEGIN
+			INSERT INTO item_history_fts (item_history_fts, rowid, title, description)
+			VALUES ('delete', old.item_id, old.title, old.description);
+		END`,
+	)
+	if err != nil {
+		return err
+	}
+
+	// 触发器缺失期间写入的历史没有索引，重建一次
+	if triggers < 2 {
+		if _, err := db.Exec("INSERT INTO item_history_fts (item_history_fts) VALUES ('rebuild')"); err != nil {
+			return err
+		}
+		logMessage("info", "历史搜索全文索引已重建")
+	}
+	historyFTS = true
+	return nil
+}
+
+// saveHistory 保存抓取到的条目，已存在的条目忽略
+func saveHistory(db *sql.DB, feedID int, entries []historyEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC().Format("2006-01-02 15:04:05")
+	for _, entry := range entries {
+		categories, _ := json.Marshal(entry.msg.Categories)
+		published := now
+		if !entry.msg.PubDate.IsZero() {
+			published = entry.msg.PubDate.UTC().Format("2006-01-02 15:04:05")
+		}
+		_, err := tx.Exec(`INSERT OR IGNORE INTO item_history (feed_id, item_key, title, link, description, content,
+			author, categories, published_at, fetched_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			feedID, entry.key, entry.msg.Title, entry.msg.Link, entry.msg.Description, entry.msg.Content,
+			entry.msg.Author, string(categories), published, now)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// pruneHistory 清理超过保留天数的历史条目
+func pruneHistory(db *sql.DB) {
+	cutoff := time.Now().UTC().AddDate(0, 0, -globalConfig.HistoryDays).Format("2006-01-02 15:04:05")
+	result, err := db.Exec("DELETE FROM item_history WHERE fetched_at < ?", cutoff)
+	if err != nil {
+		logMessage("error", fmt.Sprintf("清理历史条目失败: %v", err))
+		return
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		logMessage("debug", fmt.Sprintf("已清理 %d 条过期历史条目", n))
+	}
+}
+
+// useFullText 检查查询能否使用全文索引，trigram分词要求每个词至少3个字符
+func useFullText(terms []string) bool {
+	if !historyFTS {
+		return false
+	}
+	for _, term := range terms {
+		if utf8.RuneCountInString(term) < 3 {
+			return false
+		}
+	}
+	return true
+}
+
+// searchHistory 在用户订阅的历史条目中搜索，按发布时间倒序返回一页结果和总数
+func searchHistory(db *sql.DB, userID int64, query string, page int) ([]historyItem, int, error) {
+	terms := strings.Fields(query)
+	if len(terms) == 0 {
+		return nil, 0, nil
+	}
+
+	from := `FROM item_history h JOIN user_subscriptions us ON us.feed_id = h.feed_id AND us.user_id = ?`
+	args := []interface{}{userID}
+	var where string
+	if useFullText(terms) {
+		phrases := make([]string, len(terms))
+		for i, term := range terms {
+			phrases[i] = `"` + strings.ReplaceAll(term, `"`, `""`) + `"`
+		}
+		from += " JOIN item_history_fts ON item_history_fts.rowid = h.item_id"
+		where = "item_history_fts MATCH ?"
+		args = append(args, strings.Join(phrases, " "))
+	} else {
+		conditions := make([]string, len(terms))
+		for i, term := range terms {
+			pattern := "%" + escapeLike(term) + "%"
+			conditions[i] = `(h.title LIKE ? ESCAPE '\' OR h.description LIKE ? ESCAPE '\')`
+			args = append(args, pattern, pattern)
+		}
+		where = strings.Join(conditions, " AND ")
+	}
+
+	var total int
+	if err := db.QueryRow("SELECT COUNT(*) "+from+" WHERE "+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := db.Query("SELECT us.alias, h.title, h.link, h.published_at "+from+" WHERE "+where+
+		" ORDER BY h.published_at DESC, h.item_id DESC LIMIT ? OFFSET ?",
+		append(args, SearchPageSize, page*SearchPageSize)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var items []historyItem
+	for rows.Next() {
+		var item historyItem
+		var published string
+		if err := rows.Scan(&item.FeedName, &item.Title, &item.Link, &published); err != nil {
+			continue
+		}
+		item.Published, _ = time.Parse("2006-01-02 15:04:05", published)
+		items = append(items, item)
+	}
+	return items, total, rows.Err()
+}
+
+// escapeLike 转义LIKE中的通配符
+func escapeLike(text string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(text)
+}
+
+// testKeywordsOnHistory 用关键词匹配用户最近的历史条目，返回检查数、命中数和部分命中条目
+// 同时计入用户的全局屏蔽词，结果与实际推送一致
+func testKeywordsOnHistory(db *sql.DB, userID int64, keywords []string) (int, int, []historyItem, error) {
+	matcher, err := getUserMatcher(db, userID)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	rules := append(compileRules(keywords), matcher.blockRules()...)
+
+	rows, err := db.Query(`SELECT us.alias, h.title, h.link, h.description, h.content, h.author, h.categories, h.published_at
+		FROM item_history h JOIN user_subscriptions us ON us.feed_id = h.feed_id AND us.user_id = ?
+		ORDER BY h.published_at DESC, h.item_id DESC LIMIT ?`, userID, KeywordTestLimit)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	defer rows.Close()
+
+	checked, matched := 0, 0
+	var samples []historyItem
+	for rows.Next() {
+		var item historyItem
+		var msg Message
+		var categories, published string
+		if err := rows.Scan(&item.FeedName, &msg.Title, &msg.Link, &msg.Description, &msg.Content,
+			&msg.Author, &categories, &published); err != nil {
+			continue
+		}
+		json.Unmarshal([]byte(categories), &msg.Categories)
+		checked++
+
+		if len(matchesKeywords(newMessageContent(msg), rules, item.FeedName)) == 0 {
+			continue
+		}
+		matched++
+		if len(samples) < KeywordTestShow {
+			item.Title, item.Link = msg.Title, msg.Link
+			item.Published, _ = time.Parse("2006-01-02 15:04:05", published)
+			samples = append(samples, item)
+		}
+	}
+	return checked, matched, samples, rows.Err()
+}
+
+// formatHistoryItem 格式化一条历史结果
+func formatHistoryItem(index int, item historyItem, location *time.Location) string {
+	title := html.EscapeString(item.Title)
+	if item.Link != "" {
+		title = fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(item.Link), title)
+	}
+	return fmt.Sprintf("%d. %s\n📰 %s  🕒 %s", index, title, html.EscapeString(item.FeedName),
+		item.Published.In(location).Format("2006-01-02 15:04"))
+}
+
+// handleSearchCommand 处理 /search 命令
+func handleSearchCommand(userID int64, query string) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		messageSender.SendError(userID, 0, "请输入要搜索的内容，例如：\n/search 显卡\n/search kw:显卡&价格<3000  测试关键词在历史条目中的命中情况")
+		return
+	}
+
+	if strings.HasPrefix(query, KeywordTestPrefix) {
+		testKeywords(userID, strings.TrimSpace(strings.TrimPrefix(query, KeywordTestPrefix)))
+		return
+	}
+
+	lastSearchLock.Lock()
+	lastSearches[userID] = query
+	lastSearchLock.Unlock()
+	showSearchPage(userID, 0, 0)
+}
+
+// showSearchPage 显示用户最近一次搜索的指定页
+func showSearchPage(userID int64, messageID int, page int) {
+	lastSearchLock.Lock()
+	query, ok := lastSearches[userID]
+	lastSearchLock.Unlock()
+	if !ok {
+		messageSender.SendError(userID, messageID, "搜索已过期，请重新使用 /search 搜索")
+		return
+	}
+
+	var items []historyItem
+	var total int
+	err := withDB(func(db *sql.DB) error {
+		var err error
+		items, total, err = searchHistory(db, userID, query, page)
+		return err
+	})
+	if err != nil {
+		logMessage("error", fmt.Sprintf("搜索历史条目失败: %v", err), userID)
+		messageSender.SendError(userID, messageID, "搜索失败，请稍后重试")
+		return
+	}
+	if total == 0 {
+		keyboard := CreateBackButton()
+		messageSender.SendHTMLResponse(userID, messageID,
+			fmt.Sprintf("🔍 在你订阅的最近 %d 天内容中没有找到 \"%s\"", globalConfig.HistoryDays, html.EscapeString(query)), &keyboard)
+		return
+	}
+
+	pages := (total + SearchPageSize - 1) / SearchPageSize
+	lines := []string{fmt.Sprintf("🔍 \"%s\" 共 %d 条结果，第 %d/%d 页", html.EscapeString(query), total, page+1, pages)}
+	location := userLocation(userID)
+	for i, item := range items {
+		lines = append(lines, formatHistoryItem(page*SearchPageSize+i+1, item, location))
+	}
+
+	var nav []tgbotapi.InlineKeyboardButton
+	if page > 0 {
+		nav = append(nav, tgbotapi.NewInlineKeyboardButtonData("⬅️ 上一页", "search_"+strconv.Itoa(page-1)))
+	}
+	if page+1 < pages {
+		nav = append(nav, tgbotapi.NewInlineKeyboardButtonData("➡️ 下一页", "search_"+strconv.Itoa(page+1)))
+	}
+	var rows [][]tgbotapi.InlineKeyboardButton
+	if len(nav) > 0 {
+		rows = append(rows, nav)
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("🔙 返回主菜单", "back_to_menu"),
+	))
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	messageSender.SendHTMLResponse(userID, messageID, strings.Join(lines, "\n\n"), &keyboard, true)
+}
+
+// testKeywords 测试关键词在用户最近历史条目中的命中情况
+func testKeywords(userID int64, text string) {
+	keywords := splitKeywordInput(text)
+	if len(keywords) == 0 {
+		messageSender.SendError(userID, 0, "请在 kw: 后输入要测试的关键词")
+		return
+	}
+	if problems := validateKeywords(keywords); len(problems) > 0 {
+		messageSender.SendError(userID, 0, fmt.Sprintf("❌ 关键词格式错误：\n%s", strings.Join(problems, "\n")))
+		return
+	}
+
+	var checked, matched int
+	var samples []historyItem
+	err := withDB(func(db *sql.DB) error {
+		var err error
+		checked, matched, samples, err = testKeywordsOnHistory(db, userID, keywords)
+		return err
+	})
+	if err != nil {
+		logMessage("error", fmt.Sprintf("测试关键词失败: %v", err), userID)
+		messageSender.SendError(userID, 0, "测试关键词失败，请稍后重试")
+		return
+	}
+
+	lines := []string{fmt.Sprintf("🧪 关键词 <code>%s</code> 在最近 %d 条历史内容中命中 %d 条（已计入你的全局屏蔽词）",
+		html.EscapeString(strings.Join(keywords, ", ")), checked, matched)}
+	location := userLocation(userID)
+	for i, item := range samples {
+		lines = append(lines, formatHistoryItem(i+1, item, location))
+	}
+	if matched > len(samples) {
+		lines = append(lines, fmt.Sprintf("……仅显示最近 %d 条", len(samples)))
+	}
+	keyboard := CreateBackButton()
+	messageSender.SendHTMLResponse(userID, 0, strings.Join(lines, "\n\n"), &keyboard, true)
+}
@@ -0,0 +1,774 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// 关键词前缀
+const (
+	RegexKeywordPrefix = "re:" // 正则关键词，格式为 re:/正则/ 或 re:/正则/+RSS名称
+	WordKeywordPrefix  = "w:"  // 整词匹配，如 w:AI 不会命中 DETAIL
+	FuzzyKeywordPrefix = "~"   // 模糊匹配，格式为 ~关键词 或 ~N:关键词，N为允许的编辑距离
+
+	DefaultFuzzyDistance = 1    // 模糊匹配默认允许的编辑距离
+	MaxFuzzyDistance     = 3    // 模糊匹配允许的最大编辑距离
+	MaxRegexCacheSize    = 1000 // 正则缓存的最大条数，超出后清空重建
+)
+
+// keywordRule 解析后的单个关键词规则
+type keywordRule struct {
+	Raw     string // 原始关键词
+	Keyword string // 去掉前缀和RSS过滤后的关键词，用于展示命中结果
+	Block   bool   // 是否为屏蔽关键词
+	Scope   string // 匹配范围：default/title/description/all/author/category/link/full
+	RSSName string // 限定的RSS名称，为空表示不限
+	Exact   bool   // 是否精确匹配(不做规范化处理)
+
+	expr exprNode // 匹配条件，普通关键词为单个条件，表达式关键词为条件树
+}
+
+// keywordTerm 单个匹配条件
+type keywordTerm struct {
+	pattern  string         // 规范化(精确匹配时为小写)后的普通匹配内容
+	re       *regexp.Regexp // 正则或通配符编译结果
+	isRegex  bool           // 是否为re:正则关键词，正则匹配时保留原始大小写
+	exact    bool           // 是否精确匹配，精确匹配时与小写内容比较
+	mode     string         // 匹配方式：空为子串匹配，word为整词匹配，fuzzy为模糊匹配，numeric为数值条件
+	distance int            // 模糊匹配允许的编辑距离
+	field    string         // 数值条件的字段：price/discount/number
+	op       string         // 数值条件的比较符
+	value    float64        // 数值条件的比较值
+}
+
+// 已编译的正则缓存，避免每条消息每个用户都重新编译
+var (
+	regexCache = make(map[string]*regexp.Regexp)
+	regexMutex sync.RWMutex
+)
+
+// compileCached 编译正则并缓存结果，缓存满时清空，已编译的匹配器仍持有各自的正则不受影响
+func compileCached(pattern string) (*regexp.Regexp, error) {
+	regexMutex.RLock()
+	re, ok := regexCache[pattern]
+	regexMutex.RUnlock()
+	if ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	regexMutex.Lock()
+	if len(regexCache) >= MaxRegexCacheSize {
+		regexCache = make(map[string]*regexp.Regexp)
+	}
+	regexCache[pattern] = re
+	regexMutex.Unlock()
+	return re, nil
+}
+
+// keywordBody 去掉屏蔽、匹配范围和精确匹配前缀，返回关键词主体，用于判断关键词写法
+func keywordBody(keyword string) string {
+	body := strings.TrimPrefix(strings.TrimSpace(keyword), "-")
+	for _, s := range keywordScopes {
+		if strings.HasPrefix(body, s.prefix) {
+			body = strings.TrimPrefix(body, s.prefix)
+			break
+		}
+	}
+	body = strings.TrimSpace(body)
+	return strings.TrimSpace(strings.TrimPrefix(body, ExactKeywordPrefix))
+}
+
+// isRegexKeyword 判断关键词是否为以re:开头的正则写法
+func isRegexKeyword(keyword string) bool {
+	return strings.HasPrefix(keywordBody(keyword), RegexKeywordPrefix)
+}
+
+// isExpressionKeyword 判断关键词是否为 & | 组合的表达式
+// 只有两侧为空格或括号的 & | 才是运算符，AT&T、R&D、A|B 这类关键词仍按普通文字匹配
+func isExpressionKeyword(keyword string) bool {
+	runes := []rune(exprOperatorReplacer.Replace(keyword))
+	for i, r := range runes {
+		if (r == '&' || r == '|') && isExprOperator(runes, i) {
+			return true
+		}
+	}
+	return false
+}
+
+// isExprOperator 判断 & | 是否作为运算符使用：左侧为空白或 )，右侧为空白、( 或 !
+func isExprOperator(runes []rune, i int) bool {
+	if i == 0 || i == len(runes)-1 {
+		return false
+	}
+	prev, next := runes[i-1], runes[i+1]
+	return (unicode.IsSpace(prev) || prev == ')') && (unicode.IsSpace(next) || next == '(' || next == '!')
+}
+
+// splitKeywordInput 拆分用户输入的关键词
+// 按行处理，正则、表达式等复杂关键词可能包含空格和逗号，需单独一行整行作为一个关键词；
+// 其余关键词按空格和逗号分隔
+func splitKeywordInput(text string) []string {
+	var keywords []string
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if isComplexKeyword(line) {
+			keywords = append(keywords, line)
+			continue
+		}
+		// 替换中式逗号为美式逗号
+		line = strings.ReplaceAll(line, "，", ",")
+		for _, field := range strings.Fields(strings.ReplaceAll(line, ",", " ")) {
+			keywords = append(keywords, field)
+		}
+	}
+	return keywords
+}
+
+// 整行输入的关键词写法，只在关键词主体开头判断，避免普通文字中的 w: < 等字符被误认
+var (
+	fuzzyDistancePrefixRegex = regexp.MustCompile(`^~\s*\d+\s*:`)
+	numericPrefixRegex       = regexp.MustCompile(`^(价格|售价|price|折扣|discount|数字|num)\s*[<>=]`)
+)
+
+// isComplexKeyword 判断关键词是否需要整行输入(正则、表达式、整词短语、指定编辑距离的模糊匹配和数值条件中可能包含空格和逗号)
+func isComplexKeyword(keyword string) bool {
+	body := keywordBody(keyword)
+	return strings.HasPrefix(body, RegexKeywordPrefix) || strings.HasPrefix(body, WordKeywordPrefix) ||
+		fuzzyDistancePrefixRegex.MatchString(body) || numericPrefixRegex.MatchString(foldText(body)) ||
+		isExpressionKeyword(keyword)
+}
+
+// keywordScopes 匹配范围前缀
+var keywordScopes = []struct {
+	prefix string
+	scope  string
+}{
+	{"#t", "title"},       // 标题
+	{"#c", "description"}, // 描述
+	{"#a", "all"},         // 标题和描述
+	{"#u", "author"},      // 作者
+	{"#g", "category"},    // 分类/标签
+	{"#l", "link"},        // 链接及附件地址
+	{"#f", "full"},        // 全部字段
+}
+
+// parseKeywordRule 解析关键词字符串
+// 支持 -屏蔽、#t/#c/#a/#u/#g/#l/#f 匹配范围、关键词+RSS名称、* 通配符、re:/正则/ 和 & | ! () 表达式写法
+func parseKeywordRule(keyword string) (*keywordRule, error) {
+	rule := &keywordRule{Raw: keyword, Scope: "default"}
+	keyword = strings.TrimSpace(keyword)
+	if keyword == "" {
+		return nil, fmt.Errorf("关键词为空")
+	}
+
+	// 检查是否是屏蔽关键词
+	if strings.HasPrefix(keyword, "-") {
+		rule.Block = true
+		keyword = strings.TrimPrefix(keyword, "-")
+	}
+
+	// 检查匹配范围前缀
+	for _, s := range keywordScopes {
+		if strings.HasPrefix(keyword, s.prefix) {
+			rule.Scope = s.scope
+			keyword = strings.TrimPrefix(keyword, s.prefix)
+			break
+		}
+	}
+
+	// 移除前缀后可能存在的空格
+	keyword = strings.TrimSpace(keyword)
+
+	// 检查是否关闭规范化，进行精确匹配
+	if strings.HasPrefix(keyword, ExactKeywordPrefix) {
+		rule.Exact = true
+		keyword = strings.TrimSpace(strings.TrimPrefix(keyword, ExactKeywordPrefix))
+	}
+
+	if strings.HasPrefix(keyword, RegexKeywordPrefix) {
+		return rule, rule.parseRegex(strings.TrimPrefix(keyword, RegexKeywordPrefix))
+	}
+
+	// 检查是否包含RSS名称限制 (格式: 关键词+rssname)
+	rule.Keyword = keyword
+	if strings.Contains(keyword, "+") {
+		parts := strings.Split(keyword, "+")
+		if len(parts) == 2 {
+			rule.Keyword = strings.TrimSpace(parts[0])
+			rule.RSSName = strings.TrimSpace(parts[1])
+		}
+	}
+
+	if isExpressionKeyword(rule.Keyword) {
+		expr, err := parseExpression(rule.Keyword, rule.Exact)
+		if err != nil {
+			return nil, fmt.Errorf("表达式 %s 无效：%v", rule.Keyword, err)
+		}
+		rule.expr = expr
+		return rule, nil
+	}
+
+	term, err := newTerm(rule.Keyword, rule.Exact)
+	if err != nil {
+		return nil, err
+	}
+	rule.expr = &termNode{term: term}
+	return rule, nil
+}
+
+// newTerm 根据前缀创建整词、模糊、数值条件或普通匹配条件
+func newTerm(keyword string, exact bool) (*keywordTerm, error) {
+	if term := newNumericTerm(keyword); term != nil {
+		return term, nil
+	}
+	switch {
+	case strings.HasPrefix(keyword, WordKeywordPrefix):
+		return newWordTerm(strings.TrimSpace(strings.TrimPrefix(keyword, WordKeywordPrefix)), exact)
+	case strings.HasPrefix(keyword, FuzzyKeywordPrefix):
+		return newFuzzyTerm(strings.TrimSpace(strings.TrimPrefix(keyword, FuzzyKeywordPrefix)), exact)
+	}
+	return newPlainTerm(keyword, exact), nil
+}
+
+// newWordTerm 创建整词匹配条件，整词匹配保留空格，可用于 w:machine learning 这样的短语
+func newWordTerm(keyword string, exact bool) (*keywordTerm, error) {
+	pattern := strings.ToLower(keyword)
+	if !exact {
+		pattern = strings.TrimSpace(foldText(keyword))
+	}
+	if pattern == "" {
+		return nil, fmt.Errorf("整词关键词为空")
+	}
+	return &keywordTerm{pattern: pattern, exact: exact, mode: "word"}, nil
+}
+
+// newFuzzyTerm 创建模糊匹配条件，格式为 关键词 或 N:关键词
+func newFuzzyTerm(keyword string, exact bool) (*keywordTerm, error) {
+	distance := DefaultFuzzyDistance
+	if i := strings.Index(keyword, ":"); i > 0 {
+		if n, err := strconv.Atoi(keyword[:i]); err == nil {
+			distance = n
+			keyword = strings.TrimSpace(keyword[i+1:])
+		}
+	}
+	if distance < 1 || distance > MaxFuzzyDistance {
+		return nil, fmt.Errorf("模糊匹配的编辑距离需在 1-%d 之间：%d", MaxFuzzyDistance, distance)
+	}
+
+	pattern := strings.ToLower(keyword)
+	if !exact {
+		pattern = normalizeText(keyword)
+	}
+	if pattern == "" {
+		return nil, fmt.Errorf("模糊关键词为空")
+	}
+	// 编辑距离不小于关键词长度时任何内容都能命中
+	if distance >= len([]rune(pattern)) {
+		return nil, fmt.Errorf("模糊关键词 %s 太短，编辑距离 %d 需小于关键词长度", keyword, distance)
+	}
+	return &keywordTerm{pattern: pattern, exact: exact, mode: "fuzzy", distance: distance}, nil
+}
+
+// newPlainTerm 创建普通/通配符匹配条件
+func newPlainTerm(keyword string, exact bool) *keywordTerm {
+	term := &keywordTerm{exact: exact}
+
+	// 按通配符拆分后分别处理，避免 * 被当作标点去掉
+	parts := strings.Split(keyword, "*")
+	for i, part := range parts {
+		if exact {
+			parts[i] = strings.ToLower(part)
+		} else {
+			parts[i] = normalizeText(part)
+		}
+	}
+	term.pattern = strings.Join(parts, "*")
+
+	// 关键词全部由标点组成时规范化后为空，退回精确匹配，避免匹配所有内容
+	if !exact && strings.Trim(term.pattern, "*") == "" {
+		return newPlainTerm(keyword, true)
+	}
+
+	// 通配符转换为正则表达式，编译失败时退回普通匹配
+	if len(parts) > 1 {
+		for i, part := range parts {
+			parts[i] = regexp.QuoteMeta(part)
+		}
+		pattern := "(?s)^.*" + strings.Join(parts, ".*") + ".*$"
+		if re, err := compileCached(pattern); err == nil {
+			term.re = re
+		}
+	}
+	return term
+}
+
+// parseRegex 解析 re: 之后的内容，格式为 /正则/ 或 /正则/+RSS名称，也可省略斜杠
+func (r *keywordRule) parseRegex(body string) error {
+	pattern := body
+	if strings.HasPrefix(body, "/") {
+		end := strings.LastIndex(body, "/")
+		if end == 0 {
+			return fmt.Errorf("正则表达式缺少结尾的 /：%s", r.Raw)
+		}
+		pattern = body[1:end]
+		rest := strings.TrimSpace(body[end+1:])
+		if rest != "" {
+			if !strings.HasPrefix(rest, "+") {
+				return fmt.Errorf("正则表达式结尾 / 之后只能跟 +RSS名称：%s", r.Raw)
+			}
+			r.RSSName = strings.TrimSpace(strings.TrimPrefix(rest, "+"))
+		}
+	}
+
+	if pattern == "" {
+		return fmt.Errorf("正则表达式为空：%s", r.Raw)
+	}
+	re, err := compileCached(pattern)
+	if err != nil {
+		return fmt.Errorf("正则表达式 %s 无效：%v", pattern, err)
+	}
+
+	r.Keyword = RegexKeywordPrefix + "/" + pattern + "/"
+	r.expr = &termNode{term: &keywordTerm{re: re, isRegex: true}}
+	return nil
+}
+
+// appliesTo 检查规则是否适用于指定的RSS源
+func (r *keywordRule) appliesTo(rssName string) bool {
+	return r.RSSName == "" || strings.EqualFold(r.RSSName, rssName)
+}
+
+// match 检查规则是否命中消息内容
+func (r *keywordRule) match(content *messageContent) bool {
+	return r.expr.eval(content.get(r.Scope))
+}
+
+// matchText 参与匹配的文本，分别保留原文、小写、折叠和规范化后的形式
+type matchText struct {
+	original   string
+	lower      string
+	folded     string // 折叠后保留空白和标点，用于整词匹配
+	normalized string // 去掉空白和标点，用于普通匹配
+}
+
+// messageContent 单条消息的匹配内容，按匹配范围计算一次后供所有用户和关键词复用
+type messageContent struct {
+	msg    Message
+	scopes map[string]matchText
+}
+
+func newMessageContent(msg Message) *messageContent {
+	return &messageContent{msg: msg, scopes: make(map[string]matchText)}
+}
+
+// get 返回指定匹配范围的内容，默认只匹配标题（保持向后兼容）
+func (c *messageContent) get(scope string) matchText {
+	if text, ok := c.scopes[scope]; ok {
+		return text
+	}
+
+	var content string
+	switch scope {
+	case "description":
+		content = joinNonEmpty(c.msg.Description, c.msg.Content)
+	case "all":
+		content = joinNonEmpty(c.msg.Title, c.msg.Description, c.msg.Content)
+	case "author":
+		content = c.msg.Author
+	case "category":
+		content = strings.Join(c.msg.Categories, "\n")
+	case "link":
+		content = strings.Join(append([]string{c.msg.Link}, c.msg.Enclosures...), "\n")
+	case "full":
+		content = joinNonEmpty(c.get("all").original, c.msg.Author, c.get("category").original, c.get("link").original)
+	default:
+		content = c.msg.Title
+	}
+
+	folded := foldText(content)
+	text := matchText{original: content, lower: strings.ToLower(content), folded: folded, normalized: collapseText(folded)}
+	c.scopes[scope] = text
+	return text
+}
+
+// joinNonEmpty 拼接非空且不重复的内容，正文与描述相同时只保留一份
+func joinNonEmpty(parts ...string) string {
+	var kept []string
+	for _, part := range parts {
+		if part == "" {
+			continue
+		}
+		duplicate := false
+		for _, k := range kept {
+			if k == part {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			kept = append(kept, part)
+		}
+	}
+	return strings.Join(kept, "\n")
+}
+
+// userMatcher 用户编译好的关键词规则
+type userMatcher struct {
+	rules   []*keywordRule              // 全局关键词
+	filters map[int]*subscriptionFilter // 按订阅ID的过滤设置
+}
+
+// 用户关键词匹配器缓存，只在关键词变化时重新构建
+var (
+	matcherCache      = make(map[int64]*userMatcher)
+	matcherGeneration uint64 // 任一用户失效时递增，防止并发加载写回过期结果
+	matcherMutex      sync.RWMutex
+)
+
+// compileUserMatcher 编译用户的全部关键词
+func compileUserMatcher(keywords []string) *userMatcher {
+	return &userMatcher{rules: compileRules(keywords)}
+}
+
+// compileRules 编译关键词列表，无效关键词记录日志后跳过
+func compileRules(keywords []string) []*keywordRule {
+	var rules []*keywordRule
+	for _, keyword := range keywords {
+		if strings.TrimSpace(keyword) == "" {
+			continue
+		}
+		rule, err := parseKeywordRule(keyword)
+		if err != nil {
+			logMessage("debug", fmt.Sprintf("跳过无效关键词: %v", err))
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// getUserMatcher 获取用户的关键词匹配器，缓存中没有时从数据库加载并编译
+func getUserMatcher(db *sql.DB, userID int64) (*userMatcher, error) {
+	matcherMutex.RLock()
+	matcher, ok := matcherCache[userID]
+	generation := matcherGeneration
+	matcherMutex.RUnlock()
+	if ok {
+		return matcher, nil
+	}
+
+	keywords, err := loadUserKeywords(db, userID)
+	if err != nil {
+		return nil, err
+	}
+	matcher = compileUserMatcher(keywords)
+
+	// 加载并编译各订阅的过滤设置
+	filters, err := loadSubscriptionFilters(db, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, filter := range filters {
+		filter.rules = compileRules(filter.Keywords)
+	}
+	matcher.filters = filters
+
+	matcherMutex.Lock()
+	if matcherGeneration == generation {
+		matcherCache[userID] = matcher
+	}
+	matcherMutex.Unlock()
+	return matcher, nil
+}
+
+// invalidateUserMatcher 用户关键词变化后清除缓存，下次匹配时重新构建
+func invalidateUserMatcher(userID int64) {
+	matcherMutex.Lock()
+	delete(matcherCache, userID)
+	matcherGeneration++
+	matcherMutex.Unlock()
+}
+
+// match 检查单个条件是否命中
+func (t *keywordTerm) match(text matchText) bool {
+	if t.isRegex {
+		return t.re.MatchString(text.original)
+	}
+	switch t.mode {
+	case "numeric":
+		return t.matchNumeric(text.folded)
+	case "word":
+		if t.exact {
+			return containsWord(text.lower, t.pattern)
+		}
+		return containsWord(text.folded, t.pattern)
+	case "fuzzy":
+		if t.exact {
+			return fuzzyContains(text.lower, t.pattern, t.distance)
+		}
+		return fuzzyContains(text.normalized, t.pattern, t.distance)
+	}
+
+	content := text.normalized
+	if t.exact {
+		content = text.lower
+	}
+	if t.re != nil && t.re.MatchString(content) {
+		return true
+	}
+	return strings.Contains(content, t.pattern)
+}
+
+// validateKeywords 校验关键词，返回所有无效关键词的错误说明
+func validateKeywords(keywords []string) []string {
+	var problems []string
+	for _, keyword := range keywords {
+		if _, err := parseKeywordRule(keyword); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	return problems
+}
+
+// 表达式关键词
+// 语法：& 表示且，| 表示或，! 表示非，() 分组，优先级 ! > & > |
+// 示例：显卡&(4090|5090)&!矿卡
+
+// exprNode 表达式节点
+type exprNode interface {
+	eval(text matchText) bool
+}
+
+type termNode struct{ term *keywordTerm }
+type notNode struct{ x exprNode }
+type andNode struct{ left, right exprNode }
+type orNode struct{ left, right exprNode }
+
+func (n *termNode) eval(text matchText) bool {
+	return n.term.match(text)
+}
+
+func (n *notNode) eval(text matchText) bool {
+	return !n.x.eval(text)
+}
+
+func (n *andNode) eval(text matchText) bool {
+	return n.left.eval(text) && n.right.eval(text)
+}
+
+func (n *orNode) eval(text matchText) bool {
+	return n.left.eval(text) || n.right.eval(text)
+}
+
+// exprParser 递归下降表达式解析器
+type exprParser struct {
+	tokens []string
+	pos    int
+	exact  bool // 操作数是否精确匹配
+}
+
+// 全角运算符统一转换为半角
+var exprOperatorReplacer = strings.NewReplacer("＆", "&", "｜", "|", "！", "!", "（", "(", "）", ")")
+
+// parseExpression 解析表达式关键词
+func parseExpression(text string, exact bool) (exprNode, error) {
+	tokens, err := tokenizeExpression(exprOperatorReplacer.Replace(text))
+	if err != nil {
+		return nil, err
+	}
+
+	p := &exprParser{tokens: tokens, exact: exact}
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("多余的内容 %q", p.tokens[p.pos])
+	}
+	return node, nil
+}
+
+// tokenizeExpression 将表达式拆分为运算符和关键词
+func tokenizeExpression(text string) ([]string, error) {
+	var tokens []string
+	var current strings.Builder
+
+	flush := func() {
+		if operand := strings.TrimSpace(current.String()); operand != "" {
+			tokens = append(tokens, operand)
+		}
+		current.Reset()
+	}
+
+	runes := []rune(text)
+	for i, r := range runes {
+		switch {
+		case r == '(' || r == ')',
+			(r == '&' || r == '|') && isExprOperator(runes, i),
+			r == '!' && strings.TrimSpace(current.String()) == "": // ! 只在关键词开头表示取反
+			flush()
+			tokens = append(tokens, string(r))
+		default:
+			current.WriteRune(r)
+		}
+	}
+	flush()
+
+	for _, token := range tokens {
+		if strings.HasPrefix(token, RegexKeywordPrefix) {
+			return nil, fmt.Errorf("表达式中不支持正则关键词")
+		}
+	}
+	return tokens, nil
+}
+
+func (p *exprParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *exprParser) parseOr() (exprNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "|" {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &orNode{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseAnd() (exprNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "&" {
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &andNode{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	token := p.peek()
+	switch token {
+	case "":
+		return nil, fmt.Errorf("表达式不完整")
+	case "!":
+		p.pos++
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{x: x}, nil
+	case "(":
+		p.pos++
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("缺少右括号")
+		}
+		p.pos++
+		return node, nil
+	case "&", "|", ")":
+		return nil, fmt.Errorf("运算符 %s 位置错误", token)
+	}
+
+	p.pos++
+	term, err := newTerm(token, p.exact)
+	if err != nil {
+		return nil, err
+	}
+	return &termNode{term: term}, nil
+}
+
+// isWordRune 判断字符是否属于拉丁字母数字组成的单词，中日韩文字之间没有空格，不作为单词字符
+func isWordRune(r rune) bool {
+	if unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul) {
+		return false
+	}
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+// containsWord 检查内容中是否包含完整的单词
+// 关键词首尾是拉丁字母或数字时，相邻字符不能也是拉丁字母或数字；首尾是中文时不检查边界
+func containsWord(content, word string) bool {
+	first, _ := utf8.DecodeRuneInString(word)
+	last, _ := utf8.DecodeLastRuneInString(word)
+
+	for offset := 0; offset <= len(content); {
+		i := strings.Index(content[offset:], word)
+		if i < 0 {
+			return false
+		}
+		start := offset + i
+		end := start + len(word)
+
+		before, _ := utf8.DecodeLastRuneInString(content[:start])
+		after, _ := utf8.DecodeRuneInString(content[end:])
+		leftOK := start == 0 || !isWordRune(first) || !isWordRune(before)
+		rightOK := end == len(content) || !isWordRune(last) || !isWordRune(after)
+		if leftOK && rightOK {
+			return true
+		}
+
+		_, size := utf8.DecodeRuneInString(content[start:])
+		offset = start + size
+	}
+	return false
+}
+
+// fuzzyContains 检查内容中是否存在与关键词编辑距离不超过maxDistance的片段(Sellers近似子串匹配)
+func fuzzyContains(content, pattern string, maxDistance int) bool {
+	p := []rune(pattern)
+	prev := make([]int, len(p)+1)
+	cur := make([]int, len(p)+1)
+	for i := range prev {
+		prev[i] = i
+	}
+	if prev[len(p)] <= maxDistance {
+		return true
+	}
+
+	for _, c := range content {
+		cur[0] = 0
+		for i := 1; i <= len(p); i++ {
+			cost := 1
+			if p[i-1] == c {
+				cost = 0
+			}
+			cur[i] = min(prev[i-1]+cost, prev[i]+1, cur[i-1]+1)
+		}
+		if cur[len(p)] <= maxDistance {
+			return true
+		}
+		prev, cur = cur, prev
+	}
+	return false
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestSplitKeywordInput(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{"显卡, 4090，5090", []string{"显卡", "4090", "5090"}},
+		{"review: 4090, 5090", []string{"review:", "4090", "5090"}},
+		{"show: x", []string{"show:", "x"}},
+		{"a<b, c>d", []string{"a<b", "c>d"}},
+		{"re:/rtx\\s*40[89]0, ti/", []string{"re:/rtx\\s*40[89]0, ti/"}},
+		{"-#t re:/a b/", []string{"-#t re:/a b/"}},
+		{"w:machine learning", []string{"w:machine learning"}},
+		{"#a w:deep learning", []string{"#a w:deep learning"}},
+		{"~2:machine lerning", []string{"~2:machine lerning"}},
+		{"~abc def", []string{"~abc", "def"}},
+		{"价格 < 3000", []string{"价格 < 3000"}},
+		{"-价格>=12000+数码", []string{"-价格>=12000+数码"}},
+		{"显卡\nre:/a|b/\n苹果 香蕉", []string{"显卡", "re:/a|b/", "苹果", "香蕉"}},
+	}
+	for _, tt := range tests {
+		if got := splitKeywordInput(tt.input); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitKeywordInput(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestIsRegexKeyword(t *testing.T) {
+	tests := []struct {
+		keyword string
+		want    bool
+	}{
+		{"re:/abc/", true},
+		{"-re:/abc/", true},
+		{"#t =re:/abc/", true},
+		{"genre:科幻", false},
+		{"more:abc", false},
+	}
+	for _, tt := range tests {
+		if got := isRegexKeyword(tt.keyword); got != tt.want {
+			t.Errorf("isRegexKeyword(%q) = %v, want %v", tt.keyword, got, tt.want)
+		}
+	}
+}
+
+func TestIsExpressionKeyword(t *testing.T) {
+	tests := []struct {
+		keyword string
+		want    bool
+	}{
+		{"AT&T", false},
+		{"R&D", false},
+		{"A|B", false},
+		{"#t AT&T+美股", false},
+		{"显卡 & 4090", true},
+		{"显卡 | 4090", true},
+		{"显卡 ＆ 4090", true},
+		{"(4090 | 5090) & !矿卡", true},
+		{"显卡 &!矿卡", true},
+		{"a)&(b", true},
+		{"显卡&", false},
+	}
+	for _, tt := range tests {
+		if got := isExpressionKeyword(tt.keyword); got != tt.want {
+			t.Errorf("isExpressionKeyword(%q) = %v, want %v", tt.keyword, got, tt.want)
+		}
+	}
+}
+
+func TestTokenizeExpression(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"显卡 & (4090 | 5090) & !矿卡", []string{"显卡", "&", "(", "4090", "|", "5090", ")", "&", "!", "矿卡"}},
+		{"AT&T | R&D", []string{"AT&T", "|", "R&D"}},
+		{"Hello! & world", []string{"Hello!", "&", "world"}},
+		{"w:rtx & !~二手矿卡", []string{"w:rtx", "&", "!", "~二手矿卡"}},
+	}
+	for _, tt := range tests {
+		got, err := tokenizeExpression(tt.text)
+		if err != nil {
+			t.Errorf("tokenizeExpression(%q) error: %v", tt.text, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("tokenizeExpression(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+
+	if _, err := tokenizeExpression("显卡 & re:/a/"); err == nil {
+		t.Error("tokenizeExpression accepted a regex operand")
+	}
+}
+
+func TestParseKeywordRule(t *testing.T) {
+	tests := []struct {
+		keyword string
+		block   bool
+		scope   string
+		rssName string
+		exact   bool
+	}{
+		{"显卡", false, "default", "", false},
+		{"-矿卡", true, "default", "", false},
+		{"#t 技术+科技新闻", false, "title", "科技新闻", false},
+		{"-#a=RTX-4090", true, "all", "", true},
+		{"#a显卡 & (4090 | 5090)+二手", false, "all", "二手", false},
+		{"re:/a|b/+二手", false, "default", "二手", false},
+	}
+	for _, tt := range tests {
+		rule, err := parseKeywordRule(tt.keyword)
+		if err != nil {
+			t.Errorf("parseKeywordRule(%q) error: %v", tt.keyword, err)
+			continue
+		}
+		if rule.Block != tt.block || rule.Scope != tt.scope || rule.RSSName != tt.rssName || rule.Exact != tt.exact {
+			t.Errorf("parseKeywordRule(%q) = block %v scope %q rss %q exact %v, want %v %q %q %v",
+				tt.keyword, rule.Block, rule.Scope, rule.RSSName, rule.Exact, tt.block, tt.scope, tt.rssName, tt.exact)
+		}
+	}
+
+	for _, keyword := range []string{"", "显卡 & (4090", "显卡 & | 4090", "(a | b) c)", "re:/(/", "显卡 & re:/a/"} {
+		if _, err := parseKeywordRule(keyword); err == nil {
+			t.Errorf("parseKeywordRule(%q) succeeded, want error", keyword)
+		}
+	}
+}
+
+func TestKeywordRuleMatch(t *testing.T) {
+	tests := []struct {
+		keyword string
+		title   string
+		want    bool
+	}{
+		// 旧版保存的 AT&T、R&D、A|B 按普通文字匹配，不拆成表达式
+		{"AT&T", "AT&T 发布新套餐", true},
+		{"AT&T", "Attention please", false},
+		{"R&D", "公司加大R&D投入", true},
+		{"R&D", "Red Dead", false},
+		{"A|B", "A|B 测试结果", true},
+		{"A|B", "只有 A", false},
+		{"显卡 & (4090 | 5090) & !矿卡", "出 RTX 4090 显卡", true},
+		{"显卡 & (4090 | 5090) & !矿卡", "出 RTX 5090 显卡 矿卡", false},
+		{"显卡 & (4090 | 5090) & !矿卡", "出 RTX 3090 显卡", false},
+		{"AT&T | Verizon", "AT&T 资费上涨", true},
+		{"AT&T | Verizon", "AT 和 T", false},
+		{"显卡 ＆ ！矿卡", "全新显卡", true},
+		{"#t 技术", "技术周刊", true},
+	}
+	for _, tt := range tests {
+		rule, err := parseKeywordRule(tt.keyword)
+		if err != nil {
+			t.Errorf("parseKeywordRule(%q) error: %v", tt.keyword, err)
+			continue
+		}
+		content := newMessageContent(Message{Title: tt.title})
+		if got := rule.match(content); got != tt.want {
+			t.Errorf("rule %q match %q = %v, want %v", tt.keyword, tt.title, got, tt.want)
+		}
+	}
+}
+
+func TestCompileCachedBounded(t *testing.T) {
+	for i := 0; i <= MaxRegexCacheSize; i++ {
+		if _, err := compileCached(fmt.Sprintf("^k%d$", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	regexMutex.RLock()
+	size := len(regexCache)
+	regexMutex.RUnlock()
+	if size > MaxRegexCacheSize {
+		t.Errorf("regexCache size = %d, want <= %d", size, MaxRegexCacheSize)
+	}
+
+	re, err := compileCached("^k1$")
+	if err != nil || !re.MatchString("k1") {
+		t.Errorf("compileCached after reset = %v, %v", re, err)
+	}
+}
+
+func TestContainsWord(t *testing.T) {
+	tests := []struct {
+		content string
+		word    string
+		want    bool
+	}{
+		{"ai news", "ai", true},
+		{"detail", "ai", false},
+		{"mail ai", "ai", true},
+		{"用ai写作", "ai", true},
+		{"ai_bot", "ai", false},
+		{"gpt-4 发布", "gpt", true},
+		{"rtx4090", "rtx", false},
+		{"machine learning 入门", "machine learning", true},
+		{"machine learnings", "machine learning", false},
+		{"显卡降价", "显卡", true},
+		{"", "ai", false},
+	}
+	for _, tt := range tests {
+		if got := containsWord(tt.content, tt.word); got != tt.want {
+			t.Errorf("containsWord(%q, %q) = %v, want %v", tt.content, tt.word, got, tt.want)
+		}
+	}
+}
+
+func TestFuzzyContains(t *testing.T) {
+	tests := []struct {
+		content  string
+		pattern  string
+		distance int
+		want     bool
+	}{
+		{"new iphone 16", "iphone", 1, true},
+		{"new iphnne 16", "iphone", 1, true},
+		{"new iphne 16", "iphone", 1, true},
+		{"new ipohne 16", "iphone", 1, false},
+		{"new ipohne 16", "iphone", 2, true},
+		{"samsung galaxy", "samsnug", 2, true},
+		{"android", "iphone", 2, false},
+		{"", "iphone", 1, false},
+	}
+	for _, tt := range tests {
+		if got := fuzzyContains(tt.content, tt.pattern, tt.distance); got != tt.want {
+			t.Errorf("fuzzyContains(%q, %q, %d) = %v, want %v", tt.content, tt.pattern, tt.distance, got, tt.want)
+		}
+	}
+}
+
+func TestWordAndFuzzyTerms(t *testing.T) {
+	tests := []struct {
+		keyword string
+		title   string
+		want    bool
+	}{
+		{"w:AI", "AI 周报", true},
+		{"w:AI", "DETAIL 说明", false},
+		{"w:AI", "ＡＩ 周报", true},
+		{"=w:AI", "ai 周报", true},
+		{"w:machine learning", "Machine Learning 入门", true},
+		{"~iphone", "IPHNNE 16 Pro", true},
+		{"~iphone", "iPh-one 16", true},
+		{"~2:samsung", "Smasung S24", true},
+		{"~samsung", "Smasung S24", false},
+		{"显卡 & w:rtx & !~二手矿卡", "RTX 4090 显卡", true},
+		{"显卡 & w:rtx & !~二手矿卡", "RTX 4090 显卡 二手旷卡", false},
+	}
+	for _, tt := range tests {
+		rule, err := parseKeywordRule(tt.keyword)
+		if err != nil {
+			t.Errorf("parseKeywordRule(%q) error: %v", tt.keyword, err)
+			continue
+		}
+		if got := rule.match(newMessageContent(Message{Title: tt.title})); got != tt.want {
+			t.Errorf("rule %q match %q = %v, want %v", tt.keyword, tt.title, got, tt.want)
+		}
+	}
+
+	for _, keyword := range []string{"w:", "~a", "~4:samsung", "~0:samsung"} {
+		if _, err := parseKeywordRule(keyword); err == nil {
+			t.Errorf("parseKeywordRule(%q) succeeded, want error", keyword)
+		}
+	}
+}
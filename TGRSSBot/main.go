@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"html"
 	"io"
 	"log"
 	"net/http"
@@ -15,6 +16,8 @@ import (
 	"strings"
 	"sync"
 	"time"
+	_ "time/tzdata" // 内置时区数据，系统缺少时区数据时也能按时区名设置
+	"unicode/utf8"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	_ "github.com/mattn/go-sqlite3"
@@ -30,12 +33,21 @@ var (
 // Config 应用配置结构体
 // 从config.json文件中加载配置信息
 type Config struct {
-	BotToken  string `json:"BotToken"`  // Telegram Bot API令牌
-	ADMINIDS  int64  `json:"ADMINIDS"`  // 管理员ID，逗号分隔
-	Cycletime int    `json:"Cycletime"` // RSS检查周期(秒)
-	Debug     bool   `json:"Debug"`     // 是否开启调试模式
-	ProxyURL  string `json:"ProxyURL"`  // 代理服务器URL
-	Pushinfo  string `json:"Pushinfo"`  // 推送信息配置
+	BotToken           string `json:"BotToken"`           // Telegram Bot API令牌
+	ADMINIDS           int64  `json:"ADMINIDS"`           // 管理员ID，逗号分隔
+	Cycletime          int    `json:"Cycletime"`          // RSS检查周期(分钟)
+	Debug              bool   `json:"Debug"`              // 是否开启调试模式
+	ProxyURL           string `json:"ProxyURL"`           // 代理服务器URL
+	Pushinfo           string `json:"Pushinfo"`           // 推送信息配置
+	FetchConcurrency   int    `json:"FetchConcurrency"`   // 同时抓取的订阅数上限
+	PerHostConcurrency int    `json:"PerHostConcurrency"` // 同一站点同时抓取的订阅数上限
+	MaxFeedFailures    int    `json:"MaxFeedFailures"`    // 连续失败多少次后自动暂停订阅
+	FoldChinese        bool   `json:"FoldChinese"`        // 关键词匹配时是否统一繁体和简体
+	HistoryDays        int    `json:"HistoryDays"`        // 条目历史保留天数，用于搜索
+	Timezone           string `json:"Timezone"`           // 默认时区，用于推送统计按天重置和未设置时区的用户
+	PushTemplate       string `json:"PushTemplate"`       // 常规订阅的默认推送模板，为空时使用内置模板
+	ChannelTemplate    string `json:"ChannelTemplate"`    // TG频道订阅的默认推送模板，为空时使用内置模板
+	PushinfoTemplate   string `json:"PushinfoTemplate"`   // 额外推送接口的消息模板，为空时使用内置模板
 }
 
 // Message RSS消息结构体
@@ -45,15 +57,24 @@ type Message struct {
 	Description string    // 消息描述/内容
 	Link        string    // 原文链接
 	PubDate     time.Time // 发布时间
+	Author      string    // 作者，多个作者以逗号分隔
+	Categories  []string  // 分类/标签
+	Content     string    // 正文(content:encoded)
+	Enclosures  []string  // 附件地址
 }
 
 // Subscription RSS订阅结构体
 type Subscription struct {
-	ID      int     // 数据库中的唯一ID
-	URL     string  // RSS源URL
-	Name    string  // 订阅名称
-	Users   []int64 // 订阅用户ID列表
-	Channel int     // 是否推送给所有用户
+	ID       int              // 数据库中的唯一ID
+	URL      string           // RSS源URL
+	Name     string           // 首个订阅者设置的名称，用于日志
+	Users    []int64          // 订阅用户ID列表
+	Aliases  map[int64]string // 各用户为订阅设置的名称
+	Digest   map[int64]bool   // 使用摘要推送的用户
+	Template map[int64]string // 各用户为订阅设置的推送模板
+	Muted    map[int64]bool   // 暂时屏蔽该订阅的用户
+	Channel  int              // 是否推送给所有用户
+	Interval int              // 检查间隔(分钟)，0表示使用全局Cycletime
 }
 
 // UserState 用户状态结构体
@@ -66,12 +87,13 @@ type UserState struct {
 
 // 全局变量
 var (
-	globalConfig *Config                      // 全局配置对象
-	db           *sql.DB                      // 数据库连接
-	bot          *tgbotapi.BotAPI             // Telegram Bot API客户端
-	userStates   = make(map[int64]*UserState) // 用户状态映射表
-	stateMutex   sync.RWMutex                 // 用户状态读写锁
-	dbMutex      sync.RWMutex                 // 数据库操作读写锁
+	globalConfig  *Config                      // 全局配置对象
+	db            *sql.DB                      // 数据库连接
+	bot           *tgbotapi.BotAPI             // Telegram Bot API客户端
+	userStates    = make(map[int64]*UserState) // 用户状态映射表
+	stateMutex    sync.RWMutex                 // 用户状态读写锁
+	dbMutex       sync.RWMutex                 // 数据库操作读写锁
+	rssCheckMutex sync.Mutex                   // 保证同一时间只有一轮RSS检查
 )
 
 // 数据结构
@@ -81,22 +103,46 @@ type UserStats struct {
 }
 
 type SubscriptionInfo struct {
+	ID         int
 	Name       string
 	URL        string
 	LastUpdate string
+
+	// 订阅健康状态
+	Failures    int    // 连续失败次数
+	LastError   string // 最近一次错误
+	LastSuccess string // 最近一次成功时间(UTC)
+	LastStatus  int    // 最近一次HTTP状态码
+	Paused      bool   // 是否已暂停
+
+	Delivery   string // 推送方式：instant 或 digest
+	Channel    int    // 是否为TG频道订阅
+	Template   string // 推送模板，为空时使用默认模板
+	MutedUntil string // 屏蔽截止时间(UTC)，为空表示未屏蔽
 }
 
 var cyclenum int
 
 // 常量定义
 const (
-	MaxMessageLength = 4000             // Telegram消息最大长度
-	DatabaseTimeout  = 30 * time.Second // 数据库操作超时时间
-	HTTPTimeout      = 60 * time.Second // HTTP请求超时时间
-	LogFile          = "bot.log"        // 日志文件路径
-	DBFile           = "tgbot.db"       // 数据库文件路径
-	ConfigFile       = "config.json"    // 配置文件路径
-	DefaultCycleTime = 300              // 默认RSS检查周期(秒)
+	MaxMessageLength  = 4000             // Telegram消息最大长度
+	MaxCaptionLength  = 1024             // Telegram图片说明最大长度
+	MaxPushBodyLength = 3000             // 频道推送正文最大长度，超出时截断为纯文本
+	DatabaseTimeout   = 30 * time.Second // 数据库操作超时时间
+	HTTPTimeout       = 60 * time.Second // HTTP请求超时时间
+	LogFile           = "bot.log"        // 日志文件路径
+	DBFile            = "tgbot.db"       // 数据库文件路径
+	ConfigFile        = "config.json"    // 配置文件路径
+	DefaultCycleTime  = 300              // 默认RSS检查周期(秒)
+
+	DefaultFetchConcurrency   = 8               // 默认同时抓取的订阅数
+	DefaultPerHostConcurrency = 2               // 默认同一站点同时抓取的订阅数
+	DefaultMaxFeedFailures    = 10              // 默认连续失败多少次后暂停订阅
+	DefaultHistoryDays        = 30              // 默认条目历史保留天数
+	DefaultTimezone           = "Asia/Shanghai" // 默认时区
+
+	SeenItemRetention = 30 * 24 * time.Hour // 去重记录保留时长，超过此时长未在源中出现则清理
+	SeenLookupBatch   = 500                 // 批量查询去重记录时每次查询的条目数
 )
 
 // BotError 自定义错误类型
@@ -112,7 +158,7 @@ type PushStats struct {
 
 // 全局变量，存储当日推送统计
 var DailyPushStats = &PushStats{
-	Date:  time.Now().Format("2006-01-02"),
+	Date:  time.Now().In(defaultLocation).Format("2006-01-02"),
 	ByRSS: make(map[string]int),
 }
 
@@ -120,12 +166,12 @@ type DatabaseOperator struct {
 	db *sql.DB
 }
 
-// 重置推送统计
+// 重置推送统计，按配置的时区判断日期变更
 func resetPushStatsIfNeeded() {
 	DailyPushStats.mutex.Lock()
 	defer DailyPushStats.mutex.Unlock()
 
-	currentDate := time.Now().Format("2006-01-02")
+	currentDate := time.Now().In(defaultLocation).Format("2006-01-02")
 	if DailyPushStats.Date != currentDate {
 		// 日期变更，打印昨日统计并重置
 		if DailyPushStats.TotalPush > 0 {
@@ -145,7 +191,7 @@ func recordPush(rssName string) {
 	defer DailyPushStats.mutex.Unlock()
 
 	// 检查日期，如果日期变更则重置统计
-	currentDate := time.Now().Format("2006-01-02")
+	currentDate := time.Now().In(defaultLocation).Format("2006-01-02")
 	if DailyPushStats.Date != currentDate {
 		// 日期已变更，这里不打印，避免重复打印
 		DailyPushStats.Date = currentDate
@@ -201,6 +247,29 @@ func loadConfig() (*Config, error) {
 	if config.Cycletime <= 0 {
 		config.Cycletime = DefaultCycleTime
 	}
+	if config.FetchConcurrency <= 0 {
+		config.FetchConcurrency = DefaultFetchConcurrency
+	}
+	if config.PerHostConcurrency <= 0 {
+		config.PerHostConcurrency = DefaultPerHostConcurrency
+	}
+	if config.MaxFeedFailures <= 0 {
+		config.MaxFeedFailures = DefaultMaxFeedFailures
+	}
+	if config.HistoryDays <= 0 {
+		config.HistoryDays = DefaultHistoryDays
+	}
+	if config.Timezone == "" {
+		config.Timezone = DefaultTimezone
+	}
+	location, err := parseTimezone(config.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("Timezone配置错误: %v", err)
+	}
+	defaultLocation = location
+	if err := validateConfigTemplates(&config); err != nil {
+		return nil, err
+	}
 
 	return &config, nil
 }
@@ -268,7 +337,8 @@ func writeToLogFile(message string) {
 func createHTTPClient(proxyURL string) *http.Client {
 	// 默认传输配置
 	transport := &http.Transport{
-		MaxIdleConns:          10,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   10,
 		IdleConnTimeout:       30 * time.Second,
 		TLSHandshakeTimeout:   20 * time.Second,
 		ResponseHeaderTimeout: 60 * time.Second,
@@ -355,11 +425,58 @@ func withDB(operation func(*sql.DB) error) error {
 
 // 统一消息发送接口
 type MessageSender struct {
-	bot *tgbotapi.BotAPI
+	bot   *tgbotapi.BotAPI
+	queue *SendQueue
 }
 
 func NewMessageSender(bot *tgbotapi.BotAPI) *MessageSender {
-	return &MessageSender{bot: bot}
+	return &MessageSender{bot: bot, queue: NewSendQueue(bot)}
+}
+
+// sendOptions 推送消息的发送选项
+type sendOptions struct {
+	Silent   bool                           // 不发通知
+	Keyboard *tgbotapi.InlineKeyboardMarkup // 消息下方的按钮，可为nil
+}
+
+// apply 将发送选项应用到消息
+func (o sendOptions) apply(base *tgbotapi.BaseChat) {
+	base.DisableNotification = o.Silent
+	if o.Keyboard != nil {
+		base.ReplyMarkup = *o.Keyboard
+	}
+}
+
+// QueueHTML 将HTML消息加入发送队列，按入队顺序限速发送
+func (m *MessageSender) QueueHTML(userID int64, text string, options sendOptions, onDone func(error)) {
+	msg := tgbotapi.NewMessage(userID, text)
+	msg.ParseMode = "HTML"
+	options.apply(&msg.BaseChat)
+	m.queue.Enqueue(userID, outgoingMessage{msg: msg, onDone: onDone})
+}
+
+// QueuePushinfo 将额外推送接口的消息加入发送队列，按入队顺序限速发送
+func (m *MessageSender) QueuePushinfo(text string, onDone func(error)) {
+	m.queue.Enqueue(PushinfoQueueKey, outgoingMessage{job: func() error { return sendother(text) }, onDone: onDone})
+}
+
+// QueuePhoto 将图片消息加入发送队列，图片发送失败时改为发送带图片链接的文本
+func (m *MessageSender) QueuePhoto(userID int64, photoURL, caption string, options sendOptions, onDone func(error)) {
+	fallback := tgbotapi.NewMessage(userID, fmt.Sprintf("图片: %s\n\n%s", photoURL, caption))
+	fallback.ParseMode = "HTML"
+	options.apply(&fallback.BaseChat)
+
+	// 说明文字超出图片消息限制时直接发送文本
+	if utf8.RuneCountInString(caption) > MaxCaptionLength {
+		m.queue.Enqueue(userID, outgoingMessage{msg: fallback, onDone: onDone})
+		return
+	}
+
+	photo := tgbotapi.NewPhoto(userID, tgbotapi.FileURL(photoURL))
+	photo.Caption = caption
+	photo.ParseMode = "HTML" // 支持在说明文字中使用HTML格式
+	options.apply(&photo.BaseChat)
+	m.queue.Enqueue(userID, outgoingMessage{msg: photo, fallback: fallback, onDone: onDone})
 }
 
 // SendResponse 统一的消息发送方法
@@ -370,7 +487,7 @@ func (m *MessageSender) SendResponse(userID int64, messageID int, text string, k
 		if keyboard != nil {
 			edit.ReplyMarkup = keyboard
 		}
-		_, err := m.bot.Send(edit)
+		_, err := m.queue.Send(userID, edit)
 		return err
 	} else {
 		// 发送新消息
@@ -378,7 +495,7 @@ func (m *MessageSender) SendResponse(userID int64, messageID int, text string, k
 		if keyboard != nil {
 			msg.ReplyMarkup = *keyboard
 		}
-		_, err := m.bot.Send(msg)
+		_, err := m.queue.Send(userID, msg)
 		return err
 	}
 }
@@ -402,7 +519,7 @@ func (m *MessageSender) SendHTMLResponse(userID int64, messageID int, text strin
 			edit.ReplyMarkup = keyboard
 		}
 		logMessage("debug", "准备编辑消息", userID)
-		_, err := m.bot.Send(edit)
+		_, err := m.queue.Send(userID, edit)
 		if err != nil {
 			logMessage("error", fmt.Sprintf("编辑消息失败: %v", err), userID)
 		}
@@ -416,7 +533,7 @@ func (m *MessageSender) SendHTMLResponse(userID int64, messageID int, text strin
 			msg.ReplyMarkup = *keyboard
 		}
 		logMessage("debug", "准备发送新消息", userID)
-		_, err := m.bot.Send(msg)
+		_, err := m.queue.Send(userID, msg)
 		if err != nil {
 			logMessage("error", fmt.Sprintf("发送新消息失败: %v", err), userID)
 		}
@@ -473,6 +590,7 @@ func CreateBackButton() tgbotapi.InlineKeyboardMarkup {
 	)
 }
 
+// CreateDeleteKeyboard 创建删除按钮，回调数据使用短标识，避免长关键词超过Telegram的64字节限制
 func CreateDeleteKeyboard(items []string, prefix string) tgbotapi.InlineKeyboardMarkup {
 	const buttonsPerRow = 3
 	var keyboardRows [][]tgbotapi.InlineKeyboardButton
@@ -481,7 +599,7 @@ func CreateDeleteKeyboard(items []string, prefix string) tgbotapi.InlineKeyboard
 	for i, item := range items {
 		currentRow = append(currentRow, tgbotapi.NewInlineKeyboardButtonData(
 			fmt.Sprintf("❌ %s", item),
-			fmt.Sprintf("%s_%s", prefix, item),
+			fmt.Sprintf("%s_%s", prefix, keywordToken(item)),
 		))
 
 		if len(currentRow) == buttonsPerRow || i == len(items)-1 {
@@ -559,7 +677,7 @@ func (h *UserActionHandler) handleKeywordAction(userID int64, messageID int, act
 	switch action {
 	case "add_prompt":
 		setUserState(userID, "add_keyword", messageID, nil)
-		text := "请输入要添加的关键词，多个关键词可用逗号分隔：\n\n💡 技巧：可使用(*)或者(-)进行过滤匹配\n * 可匹配任意字符，-关键词 表示屏蔽\n示例：你*帅*   可匹配 你好帅呀！\n示例：-不喜欢  可屏蔽包含 不喜欢 的内容\n\n💡 匹配范围：可使用前缀指定匹配范围\n#t 关键词 - 只匹配标题\n#c 关键词 - 只匹配描述内容\n#a 关键词 - 匹配标题和描述\n示例：#t技术  只在标题中匹配\"技术\"\n示例：#c新闻  只在描述中匹配\"新闻\"\n示例：#a科技  在标题和描述中都匹配\"科技\"\n\n💡 RSS过滤：可使用(+)指定RSS源\n示例：技术+科技新闻  只匹配名为\"科技新闻\"的RSS源\n示例：技术  匹配所有RSS源\n\n💡 提示：全推送可用*号"
+		text := "请输入要添加的关键词，多个关键词可用逗号分隔：\n\n💡 技巧：可使用(*)或者(-)进行过滤匹配\n * 可匹配任意字符，-关键词 表示屏蔽\n示例：你*帅*   可匹配 你好帅呀！\n示例：-不喜欢  可屏蔽包含 不喜欢 的内容\n\n💡 匹配范围：可使用前缀指定匹配范围\n#t 关键词 - 只匹配标题\n#c 关键词 - 只匹配描述和正文\n#a 关键词 - 匹配标题、描述和正文\n#u 关键词 - 只匹配作者\n#g 关键词 - 只匹配分类/标签\n#l 关键词 - 只匹配链接(可按域名过滤)\n#f 关键词 - 匹配全部字段\n示例：#t技术  只在标题中匹配\"技术\"\n示例：#c新闻  只在描述中匹配\"新闻\"\n示例：#a科技  在标题和描述中都匹配\"科技\"\n示例：#u张三  只推送作者为\"张三\"的内容\n\n💡 规范化匹配：自动忽略全角半角、大小写、空格和标点\n示例：rtx 4090  可匹配 ＲＴＸ-4090\n示例：=RTX-4090  加 = 前缀关闭规范化进行精确匹配\n\n💡 整词与模糊匹配：可在表达式中使用\n示例：w:AI  只匹配独立的单词 AI，不会命中 DETAIL\n示例：~iphone  允许1个字符的差错，~2:samsung 允许2个\n\n💡 数值条件：价格/折扣/数字 加 < <= > >= = 比较，请单独一行输入\n示例：4090 & 价格<12000  标题含4090且价格低于12000\n示例：折扣>=50%  匹配5折、50% off及更大力度的折扣\n\n💡 RSS过滤：可使用(+)指定RSS源\n示例：技术+科技新闻  只匹配名为\"科技新闻\"的RSS源\n示例：技术  匹配所有RSS源\n\n💡 正则表达式：使用 re:/正则/ 格式，正则关键词请单独一行输入\n示例：re:/(?i)rtx\\s*40[89]0/  匹配 RTX 4080、rtx4090 等\n示例：re:/显卡.*(出|卖)/+二手  只匹配名为\"二手\"的RSS源\n\n💡 组合表达式：& 且、| 或、! 非、() 分组，& | 两侧需加空格，表达式请单独一行输入\n示例：显卡 & (4090 | 5090) & !矿卡\n示例：#a显卡 & (4090 | 5090)+二手  可配合匹配范围和RSS过滤使用\n不加空格的 AT&T、R&D 按普通关键词匹配\n\n💡 提示：如需全部推送或为单个订阅设置关键词，可在 查看订阅 中点击该订阅进行设置"
 		keyboard := CreateBackButton()
 		h.sender.SendResponse(userID, messageID, text, &keyboard)
 
@@ -602,11 +720,15 @@ func (h *UserActionHandler) handleSubscriptionAction(userID int64, messageID int
 ⚠️ 频道需要先转为rss才可添加
 请按以下格式输入RSS订阅信息：
 
-URL 名称 TG频道用1常规用0
+URL 名称 TG频道用1常规用0 [检查间隔(分钟)]
 
 📝 示例：
 常规订阅：https://example.com/feed 科技新闻 0
-频道订阅：https://example.com/channel/feed TG资讯播报 1`
+频道订阅：https://example.com/channel/feed TG资讯播报 1
+指定间隔：https://example.com/blog/feed 博客 0 60
+
+💡 检查间隔可省略，默认使用全局周期，最长 720 分钟；同一RSS源的检查间隔由所有订阅者共享
+💡 名称只对你自己生效，同一个RSS源其他用户可以用不同的名称订阅`
 		keyboard := CreateBackButton()
 		h.sender.SendResponse(userID, messageID, text, &keyboard)
 
@@ -618,7 +740,11 @@ URL 名称 TG频道用1常规用0
 			return
 		}
 
-		h.addSubscription(userID, messageID, data[0], data[1], data[2])
+		interval := ""
+		if len(data) > 3 {
+			interval = data[3]
+		}
+		h.addSubscription(userID, messageID, data[0], data[1], data[2], interval)
 
 	case "view":
 		h.viewSubscriptions(userID, messageID)
@@ -632,6 +758,13 @@ URL 名称 TG频道用1常规用0
 			return
 		}
 		h.deleteSubscription(userID, messageID, data[0])
+
+	case "retry":
+		if len(data) == 0 {
+			h.sender.SendError(userID, messageID, "恢复订阅失败：参数错误")
+			return
+		}
+		h.retrySubscription(userID, messageID, data[0])
 	}
 }
 
@@ -705,16 +838,27 @@ func (h *UserActionHandler) deleteKeyword(userID int64, messageID int, keyword s
 }
 
 // 订阅相关方法
-func (h *UserActionHandler) addSubscription(userID int64, messageID int, feedURL, name, channel string) {
+func (h *UserActionHandler) addSubscription(userID int64, messageID int, feedURL, name, channel, interval string) {
 	feedURL = strings.TrimSpace(feedURL)
 	name = strings.TrimSpace(name)
 
+	intervalMinutes := 0
+	if interval = strings.TrimSpace(interval); interval != "" {
+		n, err := strconv.Atoi(interval)
+		if err != nil || n <= 0 || n > MaxIntervalMinutes {
+			h.sender.SendError(userID, messageID, fmt.Sprintf("❌ 检查间隔必须是 1-%d 之间的整数(分钟)", MaxIntervalMinutes))
+			return
+		}
+		intervalMinutes = n
+	}
+
 	//if len(name) > 100 {
 	//	h.sender.SendError(userID, messageID, "❌ 订阅名称长度不能超过100个字符")
 	//	return
 	//}
 
-	if err := validateAndProcessSubscription(feedURL, name, channel, userID); err != nil {
+	notice, err := validateAndProcessSubscription(feedURL, name, channel, intervalMinutes, userID)
+	if err != nil {
 		logMessage("error", fmt.Sprintf("添加订阅失败: %v", err), userID)
 		h.sender.SendError(userID, messageID, "❌ "+err.Error())
 		return
@@ -723,6 +867,9 @@ func (h *UserActionHandler) addSubscription(userID int64, messageID int, feedURL
 	clearUserState(userID)
 	keyboard := CreateBackButton()
 	text := fmt.Sprintf("✅ 成功添加订阅：\n📰 %s\n🔗 %s", name, feedURL)
+	if notice != "" {
+		text += "\n\n" + notice
+	}
 	logMessage("info", fmt.Sprintf("✅ 成功添加订阅：📰 %s  🔗 %s", name, feedURL))
 	h.sender.SendResponse(userID, messageID, text, &keyboard)
 }
@@ -740,8 +887,8 @@ func (h *UserActionHandler) viewSubscriptions(userID int64, messageID int) {
 		return
 	}
 
-	text := h.formatSubscriptionsList(subscriptions)
-	keyboard := CreateBackButton()
+	text := h.formatSubscriptionsList(subscriptions, userLocation(userID)) + "\n\n点击下方订阅可设置该订阅的关键词过滤"
+	keyboard := createSubscriptionListKeyboard(subscriptions)
 	h.sender.SendHTMLResponse(userID, messageID, text, &keyboard)
 }
 
@@ -758,17 +905,22 @@ func (h *UserActionHandler) showDeleteSubscriptions(userID int64, messageID int)
 		return
 	}
 
-	var names []string
-	for _, sub := range subscriptions {
-		names = append(names, sub.Name)
-	}
-
-	keyboard := CreateDeleteKeyboard(names, "del_sub")
+	keyboard := createDeleteSubscriptionKeyboard(subscriptions)
 	h.sender.SendResponse(userID, messageID, "请选择要删除的订阅：", &keyboard)
 }
 
-func (h *UserActionHandler) deleteSubscription(userID int64, messageID int, subscriptionName string) {
-	result, err := removeSubscriptionForUser(userID, subscriptionName)
+func (h *UserActionHandler) deleteSubscription(userID int64, messageID int, subscriptionID string) {
+	id, ok := parseSubscriptionID(subscriptionID)
+	if !ok {
+		h.sender.SendError(userID, messageID, "无效的订阅")
+		return
+	}
+
+	result, err := removeSubscriptionForUser(userID, id)
+	if err == sql.ErrNoRows {
+		h.sender.SendError(userID, messageID, "未找到该订阅，可能已被删除")
+		return
+	}
 	if err != nil {
 		logMessage("error", fmt.Sprintf("删除订阅失败: %v", err), userID)
 		h.sender.SendError(userID, messageID, "删除订阅失败，请稍后重试")
@@ -788,13 +940,33 @@ func (h *UserActionHandler) deleteSubscription(userID int64, messageID int, subs
 	}()
 }
 
+// retrySubscription 恢复被自动暂停的订阅，下一轮检查立即重新抓取
+func (h *UserActionHandler) retrySubscription(userID int64, messageID int, subscriptionID string) {
+	id, err := strconv.Atoi(subscriptionID)
+	if err != nil {
+		h.sender.SendError(userID, messageID, "恢复订阅失败：参数错误")
+		return
+	}
+
+	name, err := resumeSubscriptionForUser(userID, id)
+	if err != nil {
+		logMessage("error", fmt.Sprintf("恢复订阅失败: %v", err), userID)
+		h.sender.SendError(userID, messageID, "恢复订阅失败，请稍后重试")
+		return
+	}
+	scheduler.reset(id)
+
+	keyboard := CreateBackButton()
+	h.sender.SendResponse(userID, messageID, fmt.Sprintf("✅ 订阅 \"%s\" 已恢复，将在下一轮检查时重新获取", name), &keyboard)
+}
+
 // 格式化方法
 func (h *UserActionHandler) formatKeywordsList(keywords []string) string {
 	var rows []string
 	var currentRow []string
 
 	for i, kw := range keywords {
-		currentRow = append(currentRow, fmt.Sprintf("%d.<code>%s</code>", i+1, kw))
+		currentRow = append(currentRow, fmt.Sprintf("%d.<code>%s</code>", i+1, html.EscapeString(kw)))
 		if i == len(keywords)-1 {
 			rows = append(rows, strings.Join(currentRow, "  "))
 		}
@@ -803,14 +975,40 @@ func (h *UserActionHandler) formatKeywordsList(keywords []string) string {
 	return fmt.Sprintf("📋 你的关键词列表（共 %d 个）：\n\n%s", len(keywords), strings.Join(rows, "\n"))
 }
 
-func (h *UserActionHandler) formatSubscriptionsList(subscriptions []SubscriptionInfo) string {
+func (h *UserActionHandler) formatSubscriptionsList(subscriptions []SubscriptionInfo, location *time.Location) string {
 	var subList []string
 	for i, sub := range subscriptions {
-		subList = append(subList, fmt.Sprintf("订阅%d.<code>%s</code>\n%s", i+1, sub.Name, sub.URL))
+		subList = append(subList, fmt.Sprintf("订阅%d.<code>%s</code>\n%s\n%s", i+1, sub.Name, sub.URL, formatSubscriptionHealth(sub, location)))
 	}
 	return fmt.Sprintf("📰 你的订阅列表（共 %d 个）：\n\n%s", len(subscriptions), strings.Join(subList, "\n"))
 }
 
+// formatSubscriptionHealth 格式化订阅的健康状态
+func formatSubscriptionHealth(sub SubscriptionInfo, location *time.Location) string {
+	var status string
+	switch {
+	case sub.Paused:
+		status = fmt.Sprintf("⏸️ 已暂停（连续失败 %d 次）", sub.Failures)
+	case sub.Failures > 0:
+		status = fmt.Sprintf("⚠️ 连续失败 %d 次", sub.Failures)
+	default:
+		status = "✅ 正常"
+	}
+
+	if sub.LastSuccess != "" {
+		if t, err := time.Parse("2006-01-02 15:04:05", sub.LastSuccess); err == nil {
+			status += "  上次成功：" + t.In(location).Format("01-02 15:04")
+		}
+	}
+	if sub.LastStatus > 0 {
+		status += fmt.Sprintf("  HTTP %d", sub.LastStatus)
+	}
+	if sub.Failures > 0 && sub.LastError != "" {
+		status += "\n❌ " + html.EscapeString(sub.LastError)
+	}
+	return status
+}
+
 // 全局实例
 var (
 	messageSender    *MessageSender
@@ -843,12 +1041,12 @@ func main() {
 源码仓库: https://github.com/IonRh/TGBot_RSS
 简介: TGBot_RSS 是一个灵活的利用TGBot信息推送订阅RSS的工具。
 探索更多：https://github.com/IonRh`, asciiArt, version, buildTime)
-	logMessage("info", fmt.Sprintf(intro+"\n"))
+	logMessage("info", intro+"\n")
 	// 初始化日志系统
 	logMessage("info", "RSS Bot 启动中...")
 
 	// 初始化数据库连接
-	db, err = sql.Open("sqlite3", fmt.Sprintf("%s?cache=shared&mode=rwc&_timeout=30000", DBFile))
+	db, err = sql.Open("sqlite3", fmt.Sprintf("%s?cache=shared&mode=rwc&_timeout=30000&_foreign_keys=on", DBFile))
 	if err != nil {
 		log.Fatal("连接数据库失败:", err)
 	}
@@ -930,6 +1128,12 @@ func handleMessage(message *tgbotapi.Message) {
 		return
 	}
 
+	// 转发回来的推送加入收藏
+	if isForwardedPush(message) {
+		saveForwardedMessage(message)
+		return
+	}
+
 	// 检查用户状态
 	state := getUserState(userID)
 	if state != nil {
@@ -971,6 +1175,12 @@ func handleStateMessage(message *tgbotapi.Message, state *UserState) {
 		handleKeywordInput(message)
 	case "add_subscription":
 		handleSubscriptionInput(message)
+	case "set_sub_keywords":
+		handleSubscriptionKeywordsInput(message, state)
+	case "set_sub_template":
+		handleSubscriptionTemplateInput(message, state)
+	case "add_block_word":
+		handleBlockWordInput(message)
 	default:
 		logMessage("warn", fmt.Sprintf("未知的用户状态: %s", state.Action), userID)
 		clearUserState(userID)
@@ -987,7 +1197,7 @@ func handleKeywordInput(message *tgbotapi.Message) {
 		return
 	}
 
-	keywords := strings.Fields(text)
+	keywords := splitKeywordInput(text)
 	if len(keywords) == 0 {
 		messageSender.SendError(userID, 0, "❌ 请输入有效的关键词")
 		return
@@ -996,17 +1206,53 @@ func handleKeywordInput(message *tgbotapi.Message) {
 	actionHandler.HandleAction(userID, 0, "keyword", "add", keywords...)
 }
 
+// handleSubscriptionKeywordsInput 处理订阅专属关键词输入
+func handleSubscriptionKeywordsInput(message *tgbotapi.Message, state *UserState) {
+	userID := message.From.ID
+	subscriptionID, ok := state.Data["subscription_id"].(int)
+	if !ok {
+		clearUserState(userID)
+		messageSender.SendError(userID, 0, "操作已过期，请重新进入订阅详情")
+		return
+	}
+
+	keywords := splitKeywordInput(strings.TrimSpace(message.Text))
+	if len(keywords) == 0 {
+		messageSender.SendError(userID, 0, "❌ 请输入有效的关键词")
+		return
+	}
+	actionHandler.saveSubscriptionKeywords(userID, subscriptionID, keywords)
+}
+
+// handleSubscriptionTemplateInput 处理订阅推送模板输入
+func handleSubscriptionTemplateInput(message *tgbotapi.Message, state *UserState) {
+	userID := message.From.ID
+	subscriptionID, ok := state.Data["subscription_id"].(int)
+	if !ok {
+		clearUserState(userID)
+		messageSender.SendError(userID, 0, "操作已过期，请重新进入订阅详情")
+		return
+	}
+
+	text := strings.TrimSpace(message.Text)
+	if text == "" {
+		messageSender.SendError(userID, 0, "❌ 请输入推送模板")
+		return
+	}
+	actionHandler.saveSubscriptionTemplate(userID, subscriptionID, text)
+}
+
 // 处理订阅输入
 func handleSubscriptionInput(message *tgbotapi.Message) {
 	userID := message.From.ID
-	parts := strings.SplitN(strings.TrimSpace(message.Text), " ", 3)
+	parts := strings.Fields(message.Text)
 
-	if len(parts) != 3 {
-		messageSender.SendError(userID, 0, "❌ 格式错误！请按照以下格式输入：\nURL 名称\n例如：https://example.com/feed 科技新闻")
+	if len(parts) != 3 && len(parts) != 4 {
+		messageSender.SendError(userID, 0, "❌ 格式错误！请按照以下格式输入：\nURL 名称 TG频道用1常规用0 [检查间隔(分钟)]\n例如：https://example.com/feed 科技新闻 0")
 		return
 	}
 
-	actionHandler.HandleAction(userID, 0, "subscription", "add", parts[0], parts[1], parts[2])
+	actionHandler.HandleAction(userID, 0, "subscription", "add", parts...)
 }
 
 // 显示主菜单
@@ -1044,28 +1290,75 @@ func showHelp(userID int64, messageID int) {
 
 🔤 <b>关键词基础</b>
 • 支持中英文，可用逗号(,)分隔多个关键词
-• 可使用正则表达式进行高级匹配
+• 可使用 <code>re:/正则/</code> 进行正则表达式匹配，正则关键词请单独一行输入
+• 示例：<code>re:/(?i)rtx\s*40[89]0/</code> 可匹配 "RTX 4080"、"rtx4090" 等
+
+🧮 <b>组合表达式</b>
+• <code>&amp;</code> 且、<code>|</code> 或、<code>!</code> 非、<code>()</code> 分组，<code>&amp;</code> <code>|</code> 两侧需加空格
+• 示例：<code>显卡 &amp; (4090 | 5090) &amp; !矿卡</code>
+• 不加空格的 <code>AT&amp;T</code>、<code>R&amp;D</code> 按普通关键词匹配
+• 可配合 #t/#c/#a 等匹配范围前缀和 +RSS名称 使用，表达式请单独一行输入
 
 🎯 <b>高级匹配</b>
 • <code>*</code> 可匹配任意字符
 • <code>-关键词</code> 表示屏蔽关键词
 • 示例：<code>你*帅*</code> 可匹配 "你好帅呀！" 等
 • 示例：<code>-你好丑</code> 可屏蔽包含 "你好丑" 的内容
+• 匹配时自动忽略全角半角、大小写、空格和标点，<code>rtx 4090</code> 可匹配 "ＲＴＸ-4090"
+• <code>=关键词</code> 关闭规范化进行精确匹配，如 <code>=RTX-4090</code>
+• <code>w:关键词</code> 整词匹配，<code>w:AI</code> 不会命中 "DETAIL"、"MAIL"
+• <code>~关键词</code> 模糊匹配，允许1个字符的差错，<code>~2:关键词</code> 允许2个(最多3个)
+
+💰 <b>数值条件</b>
+• <code>价格</code>、<code>折扣</code>、<code>数字</code> 加 <code>&lt; &lt;= &gt; &gt;= =</code> 比较，请单独一行输入
+• 示例：<code>4090 &amp; 价格&lt;12000</code> 标题含4090且价格低于12000
+• 示例：<code>折扣&gt;=50%%</code> 匹配 "5折"、"50%% off" 及更大力度的折扣
 
 🎯 <b>匹配范围</b>
 • 默认只匹配标题，如需更精确控制可使用以下前缀：
 • #t 关键词 - 只匹配标题
-• #c 关键词 - 只匹配描述内容
-• #a 关键词 - 匹配标题和描述
+• #c 关键词 - 只匹配描述和正文
+• #a 关键词 - 匹配标题、描述和正文
+• #u 关键词 - 只匹配作者
+• #g 关键词 - 只匹配分类/标签
+• #l 关键词 - 只匹配链接，可用于按域名过滤
+• #f 关键词 - 匹配全部字段（标题、描述、正文、作者、分类、链接）
 • 示例：<code>#t技术</code> 只在标题中匹配"技术"
+• 示例：<code>#g优惠券</code> 只匹配带有"优惠券"标签的内容
 
 📡 <b>RSS过滤(可配合高级匹配使用)</b>
 • <code>关键词+RSS名称</code> 只匹配指定RSS源
 • 示例：<code>技术+科技新闻</code> 只匹配名为 "科技新闻" 的RSS源
 • 不加"+RSS名称"则匹配所有订阅源
 
+🔍 <b>搜索与测试</b>
+• <code>/search 关键词</code> 搜索你订阅的最近 %d 天内容，可翻页查看
+• <code>/search kw:关键词</code> 测试关键词在最近内容中会命中哪些条目，写法与添加关键词相同
+
+📬 <b>摘要推送</b>
+• 在 查看订阅 中点击订阅，可在即时推送和摘要推送之间切换
+• 摘要推送的订阅命中后先保存，按设置的时间汇总成一条消息推送
+• <code>/digest 21:30</code> 每天21:30推送，<code>/digest 6h</code> 每6小时推送，不设置默认每天09:00
+
+🎨 <b>推送模板</b>
+• 在 查看订阅 中点击订阅，点击 "🎨 设置推送模板" 自定义该订阅的推送格式，保存前会校验输出
+• <code>/preview 订阅名称</code> 用该订阅最近一条内容预览推送效果
+
+🔘 <b>推送按钮</b>
+• 每条推送下方可直接 打开原文、收藏、屏蔽此源1天、删除命中的关键词或添加屏蔽词
+• 屏蔽的订阅可在订阅详情中点击 "🔔 恢复推送" 提前恢复
+
+⭐ <b>收藏</b>
+• 点击推送下方的 "⭐ 收藏"，或把推送消息转发给我即可收藏
+• <code>/saved</code> 分页查看和删除收藏，<code>/saved md</code> 导出为Markdown，<code>/saved rss</code> 导出为RSS文件
+
+🌙 <b>时区与免打扰</b>
+• <code>/timezone Asia/Tokyo</code> 或 <code>/timezone UTC+9</code> 设置时区，推送时间和摘要时间按此时区显示和计算
+• <code>/quiet 23:00-07:00</code> 免打扰时段内暂存推送，结束后统一发送
+• <code>/quiet 23:00-07:00 silent</code> 免打扰时段内静音推送，<code>/quiet off</code> 关闭
+
 📦 源码仓库: github.com/IonRh/TGBot_RSS
-🔧 问题反馈: https://t.me/IonMagic`, count)
+🔧 问题反馈: https://t.me/IonMagic`, count, globalConfig.HistoryDays)
 
 	keyboard := CreateBackButton()
 	messageSender.SendHTMLResponse(userID, messageID, helpText, &keyboard, true)
@@ -1101,6 +1394,30 @@ func handleCommand(message *tgbotapi.Message) {
 		// 发送帮助信息
 		showHelp(userID, 0)
 
+	case "search":
+		// 搜索历史条目
+		handleSearchCommand(userID, message.CommandArguments())
+
+	case "digest":
+		// 查看或设置摘要推送时间
+		handleDigestCommand(userID, message.CommandArguments())
+
+	case "preview":
+		// 预览订阅的推送模板
+		handlePreviewCommand(userID, message.CommandArguments())
+
+	case "saved":
+		// 查看或导出收藏
+		handleSavedCommand(userID, message.CommandArguments())
+
+	case "timezone":
+		// 查看或设置时区
+		handleTimezoneCommand(userID, message.CommandArguments())
+
+	case "quiet":
+		// 查看或设置免打扰时段
+		handleQuietCommand(userID, message.CommandArguments())
+
 	// 可添加更多命令处理
 	default:
 		// 未知命令
@@ -1123,6 +1440,12 @@ func handleCallbackQuery(callbackQuery *tgbotapi.CallbackQuery) {
 		}
 	}()
 
+	// 推送消息上的按钮带签名，单独处理并回应
+	if strings.HasPrefix(data, PushActionPrefix) {
+		handlePushAction(callbackQuery)
+		return
+	}
+
 	// 回应回调查询以停止按钮加载动画
 	callback := tgbotapi.NewCallback(callbackQuery.ID, "")
 	if _, err := bot.Request(callback); err != nil {
@@ -1130,7 +1453,8 @@ func handleCallbackQuery(callbackQuery *tgbotapi.CallbackQuery) {
 	}
 
 	// 清除用户状态（除非是需要输入的操作）
-	if data != "add_keyword" && data != "add_subscription" {
+	if data != "add_keyword" && data != "add_subscription" && !strings.HasPrefix(data, "sub_keywords_") &&
+		!strings.HasPrefix(data, "sub_template_") {
 		clearUserState(userID)
 	}
 
@@ -1161,9 +1485,83 @@ func handleCallbackQuery(callbackQuery *tgbotapi.CallbackQuery) {
 		showHelp(userID, messageID)
 
 	case strings.HasPrefix(data, "del_kw_"):
+		// 回调数据为关键词的短标识，找不到时按旧版按钮的原始关键词处理
 		keyword := strings.TrimPrefix(data, "del_kw_")
+		if keywords, err := getKeywordsForUser(userID); err == nil {
+			if k, ok := findKeywordByToken(keywords, keyword); ok {
+				keyword = k
+			}
+		}
 		actionHandler.HandleAction(userID, messageID, "keyword", "delete", keyword)
 
+	case strings.HasPrefix(data, "retry_sub_"):
+		subscriptionID := strings.TrimPrefix(data, "retry_sub_")
+		actionHandler.HandleAction(userID, messageID, "subscription", "retry", subscriptionID)
+
+	case strings.HasPrefix(data, "sub_detail_"):
+		if id, ok := parseSubscriptionID(strings.TrimPrefix(data, "sub_detail_")); ok {
+			actionHandler.showSubscriptionDetail(userID, messageID, id)
+		}
+
+	case strings.HasPrefix(data, "sub_filter_"):
+		// 格式：sub_filter_<订阅ID>_<模式>
+		parts := strings.SplitN(strings.TrimPrefix(data, "sub_filter_"), "_", 2)
+		if id, ok := parseSubscriptionID(parts[0]); ok && len(parts) == 2 {
+			actionHandler.setSubscriptionFilter(userID, messageID, id, parts[1])
+		}
+
+	case strings.HasPrefix(data, "sub_delivery_"):
+		// 格式：sub_delivery_<订阅ID>_<推送方式>
+		parts := strings.SplitN(strings.TrimPrefix(data, "sub_delivery_"), "_", 2)
+		if id, ok := parseSubscriptionID(parts[0]); ok && len(parts) == 2 {
+			actionHandler.switchSubscriptionDelivery(userID, messageID, id, parts[1])
+		}
+
+	case strings.HasPrefix(data, "sub_template_"):
+		if id, ok := parseSubscriptionID(strings.TrimPrefix(data, "sub_template_")); ok {
+			actionHandler.promptSubscriptionTemplate(userID, messageID, id)
+		}
+
+	case strings.HasPrefix(data, "sub_unmute_"):
+		if id, ok := parseSubscriptionID(strings.TrimPrefix(data, "sub_unmute_")); ok {
+			if err := unmuteFeedForUser(userID, id); err != nil {
+				logMessage("error", fmt.Sprintf("恢复推送失败: %v", err), userID)
+			}
+			actionHandler.showSubscriptionDetail(userID, messageID, id)
+		}
+
+	case strings.HasPrefix(data, "sub_keywords_"):
+		if id, ok := parseSubscriptionID(strings.TrimPrefix(data, "sub_keywords_")); ok {
+			actionHandler.promptSubscriptionKeywords(userID, messageID, id)
+		}
+
+	case strings.HasPrefix(data, "search_"):
+		if page, err := strconv.Atoi(strings.TrimPrefix(data, "search_")); err == nil && page >= 0 {
+			showSearchPage(userID, messageID, page)
+		}
+
+	case data == "saved_md":
+		exportSavedMarkdown(userID)
+
+	case data == "saved_rss":
+		exportSavedRSS(userID)
+
+	case strings.HasPrefix(data, "saved_del_"):
+		// 格式：saved_del_<收藏ID>_<页码>
+		parts := strings.SplitN(strings.TrimPrefix(data, "saved_del_"), "_", 2)
+		if len(parts) == 2 {
+			itemID, err1 := strconv.ParseInt(parts[0], 10, 64)
+			page, err2 := strconv.Atoi(parts[1])
+			if err1 == nil && err2 == nil && page >= 0 {
+				removeSavedItem(userID, messageID, itemID, page)
+			}
+		}
+
+	case strings.HasPrefix(data, "saved_"):
+		if page, err := strconv.Atoi(strings.TrimPrefix(data, "saved_")); err == nil && page >= 0 {
+			showSavedPage(userID, messageID, page)
+		}
+
 	case strings.HasPrefix(data, "del_sub_"):
 		subscription := strings.TrimPrefix(data, "del_sub_")
 		actionHandler.HandleAction(userID, messageID, "subscription", "delete", subscription)
@@ -1185,6 +1583,7 @@ func createMainMenuKeyboard() tgbotapi.InlineKeyboardMarkup {
 		),
 		tgbotapi.NewInlineKeyboardRow(
 			tgbotapi.NewInlineKeyboardButtonData("🗑️ 删除关键词", "delete_keyword"),
+			tgbotapi.NewInlineKeyboardButtonData("⭐ 我的收藏", "saved_0"),
 		),
 		// 订阅管理行
 		tgbotapi.NewInlineKeyboardRow(
@@ -1203,7 +1602,7 @@ func createMainMenuKeyboard() tgbotapi.InlineKeyboardMarkup {
 // sendMessage 发送普通文本消息
 func sendMessage(userID int64, text string) {
 	msg := tgbotapi.NewMessage(userID, text)
-	if _, err := bot.Send(msg); err != nil {
+	if _, err := messageSender.queue.Send(userID, msg); err != nil {
 		logMessage("error", fmt.Sprintf("发送消息失败: %v", err), userID)
 	}
 }
@@ -1212,83 +1611,21 @@ func sendMessage(userID int64, text string) {
 func sendHTMLMessage(userID int64, text string) {
 	msg := tgbotapi.NewMessage(userID, text)
 	msg.ParseMode = "HTML" // 设置解析模式为HTML
-	if _, err := bot.Send(msg); err != nil {
+	if _, err := messageSender.queue.Send(userID, msg); err != nil {
 		logMessage("error", fmt.Sprintf("发送HTML消息失败: %v", err), userID)
 	}
 }
 
-func sendPhotoMessage(userID int64, photoURL, caption string) {
-	msg := tgbotapi.NewPhoto(userID, tgbotapi.FileURL(photoURL))
-	msg.Caption = caption
-	msg.ParseMode = "HTML" // 支持在说明文字中使用HTML格式
-
-	if _, err := bot.Send(msg); err != nil {
-		logMessage("error", fmt.Sprintf("发送图片消息失败: %v", err), userID)
-		// 如果发送图片失败，尝试发送纯文本消息
-		fallbackMsg := fmt.Sprintf("图片: %s\n\n%s", photoURL, caption)
-		sendHTMLMessage(userID, fallbackMsg)
-	}
-}
-
 // 数据库操作函数
 func initDatabase() error {
-	// 表定义
-	tables := map[string]string{
-		"subscriptions": `CREATE TABLE IF NOT EXISTS subscriptions (
-			subscription_id INTEGER PRIMARY KEY AUTOINCREMENT, -- 订阅ID
-			rss_url TEXT NOT NULL,                             -- RSS源URL
-			rss_name TEXT NOT NULL UNIQUE,                     -- 订阅名称（唯一）
-			users TEXT NOT NULL DEFAULT ',',                   -- 订阅用户列表，格式为",user_id,user_id,"
-			channel INTEGER DEFAULT 0                       -- 是否推送给所有用户(0/1)
-		)`,
-		"user_keywords": `CREATE TABLE IF NOT EXISTS user_keywords (
-			user_id INTEGER PRIMARY KEY,                       -- 用户ID
-			keywords TEXT NOT NULL DEFAULT '[]'               -- 关键词列表，JSON格式
-		)`,
-		"feed_data": `CREATE TABLE IF NOT EXISTS feed_data (
-			rss_name TEXT PRIMARY KEY,                         -- 订阅名称
-			last_update_time TEXT, -- 最后更新时间
-			latest_title TEXT DEFAULT ''                      -- 最新文章标题
-		)`,
-	}
-
-	// 创建表
-	for name, tablesql := range tables {
-		if err := withDB(func(db *sql.DB) error {
-			_, err := db.Exec(tablesql)
-			return err
-		}); err != nil {
-			return fmt.Errorf("创建表 %s 失败: %v", name, err)
-		}
-		logMessage("debug", fmt.Sprintf("数据库表 %s 已创建或已存在", name))
-	}
-
-	// 索引定义
-	indexes := []struct {
-		name string
-		sql  string
-	}{
-		{
-			name: "idx_subscriptions_users",
-			sql:  "CREATE INDEX IF NOT EXISTS idx_subscriptions_users ON subscriptions(users)",
-		},
-		{
-			name: "idx_feed_data_update_time",
-			sql:  "CREATE INDEX IF NOT EXISTS idx_feed_data_update_time ON feed_data(last_update_time)",
-		},
-	}
-
-	// 创建索引
-	for _, index := range indexes {
-		if err := withDB(func(db *sql.DB) error {
-			_, err := db.Exec(index.sql)
-			return err
-		}); err != nil {
-			logMessage("warn", fmt.Sprintf("创建索引 %s 失败: %v", index.name, err))
-			// 索引创建失败不阻止程序运行
-		} else {
-			logMessage("debug", fmt.Sprintf("索引 %s 已创建或已存在", index.name))
-		}
+	// 按版本执行数据库迁移
+	if err := withDB(migrateSchema); err != nil {
+		return err
+	}
+
+	// 启用历史搜索全文索引，失败时退回LIKE查询
+	if err := withDB(ensureHistorySearch); err != nil {
+		logMessage("warn", fmt.Sprintf("启用历史搜索全文索引失败，使用LIKE查询: %v", err))
 	}
 
 	logMessage("info", "数据库初始化完成")
@@ -1333,6 +1670,13 @@ func addKeywordsForUser(userID int64, newKeywords []string) (string, error) {
 	// 处理逗号分隔的关键词
 	var processedKeywords []string
 	for _, k := range newKeywords {
+		// 正则和表达式关键词中的逗号属于关键词本身，不做分割
+		if isComplexKeyword(k) {
+			if trimmed := strings.TrimSpace(k); trimmed != "" {
+				processedKeywords = append(processedKeywords, trimmed)
+			}
+			continue
+		}
 		// 替换中式逗号为美式逗号
 		k = strings.ReplaceAll(k, "，", ",")
 		// 按逗号分割
@@ -1350,6 +1694,11 @@ func addKeywordsForUser(userID int64, newKeywords []string) (string, error) {
 		}
 	}
 
+	// 校验关键词，存在无效的正则表达式时拒绝本次添加
+	if problems := validateKeywords(processedKeywords); len(problems) > 0 {
+		return fmt.Sprintf("❌ 关键词格式错误，本次未添加任何关键词：\n%s", strings.Join(problems, "\n")), nil
+	}
+
 	// 添加新关键词并去重
 	var addedCount int
 	for _, k := range processedKeywords {
@@ -1394,6 +1743,9 @@ func addKeywordsForUser(userID int64, newKeywords []string) (string, error) {
 				string(keywordsJSON), userID)
 		} else {
 			// 插入新记录
+			if err := ensureUser(db, userID); err != nil {
+				return err
+			}
 			_, err = db.Exec("INSERT INTO user_keywords (user_id, keywords) VALUES (?, ?)",
 				userID, string(keywordsJSON))
 		}
@@ -1403,6 +1755,7 @@ func addKeywordsForUser(userID int64, newKeywords []string) (string, error) {
 	if err != nil {
 		return "", err
 	}
+	invalidateUserMatcher(userID)
 
 	// 构建关键词列表字符串
 	// 每行显示4个关键词
@@ -1462,6 +1815,7 @@ func removeKeywordForUser(userID int64, keyword string) (string, error) {
 	if err != nil {
 		return "", err
 	}
+	invalidateUserMatcher(userID)
 
 	// 如果没有剩余关键词，直接返回删除成功的消息
 	if len(newKeywords) == 0 {
@@ -1493,9 +1847,10 @@ func getSubscriptionsForUser(userID int64) ([]SubscriptionInfo, error) {
 	var subscriptions []SubscriptionInfo
 
 	err := withDB(func(db *sql.DB) error {
-		// 获取所有订阅
-		rows, err := db.Query(`SELECT rss_name, rss_url, users FROM subscriptions`)
-
+		rows, err := db.Query(`SELECT f.feed_id, us.alias, f.rss_url, f.consecutive_failures, f.last_error,
+			f.last_success, f.last_status, f.paused, us.delivery, f.channel, us.template, us.muted_until
+			FROM user_subscriptions us JOIN feeds f ON f.feed_id = us.feed_id
+			WHERE us.user_id = ? ORDER BY us.created_at, f.feed_id`, userID)
 		if err != nil {
 			return err
 		}
@@ -1503,46 +1858,20 @@ func getSubscriptionsForUser(userID int64) ([]SubscriptionInfo, error) {
 
 		for rows.Next() {
 			var sub SubscriptionInfo
-			var usersStr string
-			if err := rows.Scan(&sub.Name, &sub.URL, &usersStr); err != nil {
+			if err := rows.Scan(&sub.ID, &sub.Name, &sub.URL, &sub.Failures, &sub.LastError,
+				&sub.LastSuccess, &sub.LastStatus, &sub.Paused, &sub.Delivery, &sub.Channel, &sub.Template, &sub.MutedUntil); err != nil {
 				continue
 			}
-
-			// 解析用户列表
-			var users []int64
-			if err := json.Unmarshal([]byte(usersStr), &users); err != nil {
-				// 如果解析失败，可能是旧格式，尝试转换
-				if strings.HasPrefix(usersStr, ",") && strings.HasSuffix(usersStr, ",") {
-					userIDs := strings.Split(strings.Trim(usersStr, ","), ",")
-					for _, userIDStr := range userIDs {
-						if userIDStr == "" {
-							continue
-						}
-						if uid, err := strconv.ParseInt(userIDStr, 10, 64); err == nil && uid == userID {
-							// 旧格式匹配到了用户
-							subscriptions = append(subscriptions, sub)
-							break
-						}
-					}
-				}
-				continue
-			}
-
-			// 检查用户是否在列表中
-			for _, uid := range users {
-				if uid == userID {
-					subscriptions = append(subscriptions, sub)
-					break
-				}
-			}
+			subscriptions = append(subscriptions, sub)
 		}
-		return nil
+		return rows.Err()
 	})
 
 	return subscriptions, err
 }
 
-func removeSubscriptionForUser(userID int64, subscriptionName string) (string, error) {
+// removeSubscriptionForUser 取消用户的订阅，没有其他用户订阅时删除整个订阅源
+func removeSubscriptionForUser(userID int64, feedID int) (string, error) {
 	var result string
 
 	err := withDB(func(db *sql.DB) error {
@@ -1552,224 +1881,183 @@ func removeSubscriptionForUser(userID int64, subscriptionName string) (string, e
 		}
 		defer tx.Rollback()
 
-		var usersStr string
-		err = tx.QueryRow("SELECT users FROM subscriptions WHERE rss_name = ?", subscriptionName).Scan(&usersStr)
+		var alias string
+		err = tx.QueryRow("SELECT alias FROM user_subscriptions WHERE user_id = ? AND feed_id = ?", userID, feedID).Scan(&alias)
 		if err != nil {
 			return err
 		}
 
-		// 解析用户列表
-		var users []int64
-		var newUsers []int64
-		if err := json.Unmarshal([]byte(usersStr), &users); err != nil {
-			// 如果解析失败，可能是旧格式，尝试转换
-			if strings.HasPrefix(usersStr, ",") && strings.HasSuffix(usersStr, ",") {
-				userStrs := strings.Split(strings.Trim(usersStr, ","), ",")
-				for _, userStr := range userStrs {
-					if userStr == "" {
-						continue
-					}
-					if uid, err := strconv.ParseInt(userStr, 10, 64); err == nil {
-						if uid != userID {
-							newUsers = append(newUsers, uid)
-						}
-					}
-				}
-
-				// 如果是旧格式，转换为新格式
-				usersJSON, err := json.Marshal(newUsers)
-				if err != nil {
-					return err
-				}
-
-				if len(newUsers) == 0 {
-					// 删除整个订阅
-					_, err = tx.Exec("DELETE FROM subscriptions WHERE rss_name = ?", subscriptionName)
-					if err == nil {
-						_, err = tx.Exec("DELETE FROM feed_data WHERE rss_name = ?", subscriptionName)
-					}
-					result = fmt.Sprintf("✅ 订阅 \"%s\" 已被完全删除", subscriptionName)
-				} else {
-					// 更新用户列表
-					_, err = tx.Exec("UPDATE subscriptions SET users = ? WHERE rss_name = ?", string(usersJSON), subscriptionName)
-					result = fmt.Sprintf("✅ 你已取消订阅 \"%s\"", subscriptionName)
-				}
-
-				if err != nil {
-					return err
-				}
-
-				return tx.Commit()
-			}
+		// 删除该用户的订阅关系、过滤设置和未推送的摘要
+		if _, err := tx.Exec("DELETE FROM user_subscriptions WHERE user_id = ? AND feed_id = ?", userID, feedID); err != nil {
 			return err
 		}
-
-		// 过滤掉要删除的用户
-		for _, uid := range users {
-			if uid != userID {
-				newUsers = append(newUsers, uid)
-			}
+		if _, err := tx.Exec("DELETE FROM subscription_filters WHERE user_id = ? AND subscription_id = ?", userID, feedID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("DELETE FROM digest_items WHERE user_id = ? AND feed_id = ?", userID, feedID); err != nil {
+			return err
 		}
 
-		usersJSON, err := json.Marshal(newUsers)
-		if err != nil {
+		var remaining int
+		if err := tx.QueryRow("SELECT COUNT(*) FROM user_subscriptions WHERE feed_id = ?", feedID).Scan(&remaining); err != nil {
 			return err
 		}
 
-		if len(newUsers) == 0 {
-			// 删除整个订阅
-			_, err = tx.Exec("DELETE FROM subscriptions WHERE rss_name = ?", subscriptionName)
-			if err == nil {
-				_, err = tx.Exec("DELETE FROM feed_data WHERE rss_name = ?", subscriptionName)
+		if remaining == 0 {
+			// 没有其他用户订阅，删除整个订阅
+			for _, query := range []string{
+				"DELETE FROM seen_items WHERE subscription_id = ?",
+				"DELETE FROM subscription_filters WHERE subscription_id = ?",
+				"DELETE FROM feed_data WHERE feed_id = ?",
+				"DELETE FROM feeds WHERE feed_id = ?",
+			} {
+				if _, err := tx.Exec(query, feedID); err != nil {
+					return err
+				}
 			}
-			result = fmt.Sprintf("✅ 订阅 \"%s\" 已被完全删除", subscriptionName)
+			result = fmt.Sprintf("✅ 订阅 \"%s\" 已被完全删除", alias)
 		} else {
-			// 更新用户列表
-			_, err = tx.Exec("UPDATE subscriptions SET users = ? WHERE rss_name = ?", string(usersJSON), subscriptionName)
-			result = fmt.Sprintf("✅ 你已取消订阅 \"%s\"", subscriptionName)
-		}
-
-		if err != nil {
-			return err
+			result = fmt.Sprintf("✅ 你已取消订阅 \"%s\"", alias)
 		}
 
 		return tx.Commit()
 	})
+	if err == nil {
+		invalidateUserMatcher(userID)
+	}
 
 	return result, err
 }
 
-func getUserStats(userID int64) (*UserStats, error) {
-	stats := &UserStats{}
-
-	err := withDB(func(db *sql.DB) error {
-		// 获取用户订阅数
-		subscriptions, err := getSubscriptionsForUser(userID)
-		stats.SubscriptionCount = len(subscriptions)
+// resumeSubscriptionForUser 恢复用户订阅的已暂停订阅并清零失败次数，返回订阅名称
+func resumeSubscriptionForUser(userID int64, subscriptionID int) (string, error) {
+	sub, err := findSubscriptionForUser(userID, subscriptionID)
+	if err != nil {
+		return "", err
+	}
 
-		// 获取用户关键词数
-		keywords, err := getKeywordsForUser(userID)
-		if err == nil {
-			stats.KeywordCount = len(keywords)
-		}
+	err = withDB(func(db *sql.DB) error {
+		_, err := db.Exec("UPDATE feeds SET paused = 0, consecutive_failures = 0 WHERE feed_id = ?", subscriptionID)
+		return err
+	})
+	if err == nil {
+		logMessage("info", fmt.Sprintf("订阅 %s 已恢复", sub.Name), userID)
+	}
+	return sub.Name, err
+}
 
-		// 获取总用户数
-		userSet := make(map[int64]bool)
+func getUserStats(userID int64) (*UserStats, error) {
+	stats := &UserStats{}
 
-		// 从user_keywords表获取用户
-		rows, err := db.Query("SELECT user_id FROM user_keywords")
-		if err == nil {
-			defer rows.Close()
-			for rows.Next() {
-				var uid int64
-				if err := rows.Scan(&uid); err == nil {
-					userSet[uid] = true
-				}
-			}
-		}
+	// 获取用户关键词数
+	keywords, err := getKeywordsForUser(userID)
+	if err == nil {
+		stats.KeywordCount = len(keywords)
+	}
 
-		// 从subscriptions表获取用户
-		rows, err = db.Query("SELECT users FROM subscriptions")
-		if err == nil {
-			defer rows.Close()
-			for rows.Next() {
-				var users string
-				if err := rows.Scan(&users); err != nil {
-					continue
-				}
-				userIDs := strings.Split(strings.Trim(users, ","), ",")
-				for _, userIDStr := range userIDs {
-					if userIDStr == "" {
-						continue
-					}
-					if uid, err := strconv.ParseInt(userIDStr, 10, 64); err == nil {
-						userSet[uid] = true
-					}
-				}
-			}
-		}
-		return nil
+	// 获取用户订阅数
+	err = withDB(func(db *sql.DB) error {
+		return db.QueryRow("SELECT COUNT(*) FROM user_subscriptions WHERE user_id = ?", userID).Scan(&stats.SubscriptionCount)
 	})
-	//fmt.Println(stats)
 	return stats, err
 }
 
-func validateAndProcessSubscription(feedURL, name, channel string, userID int64) error {
+// validateAndProcessSubscription 校验并添加订阅，返回需要告知用户的提示
+func validateAndProcessSubscription(feedURL, name, channel string, interval int, userID int64) (string, error) {
 	// 验证URL格式
 	parsedURL, err := url.Parse(feedURL)
 	if err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") {
-		return fmt.Errorf("无效的URL格式，请使用http或https开头的完整URL")
+		return "", fmt.Errorf("无效的URL格式，请使用http或https开头的完整URL")
 	}
 
 	// 验证RSS源有效性
 	if valid, errMsg := verifyRSSFeed(feedURL); !valid {
-		return fmt.Errorf("RSS源验证失败: %s", errMsg)
+		return "", fmt.Errorf("RSS源验证失败: %s", errMsg)
 	}
 
-	return withDB(func(db *sql.DB) error {
+	var notice string
+	err = withDB(func(db *sql.DB) error {
 		tx, err := db.Begin()
 		if err != nil {
 			return err
 		}
 		defer tx.Rollback()
 
-		// 检查订阅是否已存在
-		var existingUsersStr string
-		err = tx.QueryRow("SELECT users FROM subscriptions WHERE rss_url = ? OR rss_name = ?", feedURL, name).Scan(&existingUsersStr)
+		if err := ensureUser(tx, userID); err != nil {
+			return err
+		}
+
+		// 订阅名称只需在该用户的订阅中唯一
+		var existingURL string
+		err = tx.QueryRow(`SELECT f.rss_url FROM user_subscriptions us JOIN feeds f ON f.feed_id = us.feed_id
+			WHERE us.user_id = ? AND us.alias = ?`, userID, name).Scan(&existingURL)
+		if err == nil {
+			return fmt.Errorf("你已有名为 \"%s\" 的订阅(%s)，请换一个名称", name, existingURL)
+		} else if err != sql.ErrNoRows {
+			return err
+		}
+
+		// 同一个RSS源按规范化URL只保存一份
+		canonicalURL := canonicalFeedURL(feedURL)
+		var feedID int64
+		err = tx.QueryRow("SELECT feed_id FROM feeds WHERE rss_url = ?", canonicalURL).Scan(&feedID)
 
 		if err == sql.ErrNoRows {
-			// 新订阅
-			usersJSON, err := json.Marshal([]int64{userID})
+			// 新订阅源
+			res, err := tx.Exec(`
+				INSERT INTO feeds (rss_url, rss_name, channel, poll_interval)
+				VALUES (?, ?, ?, ?)
+			`, canonicalURL, name, channel, interval)
 			if err != nil {
 				return err
 			}
-
-			_, err = tx.Exec(`
-				INSERT INTO subscriptions (rss_url, rss_name, users, channel)
-				VALUES (?, ?, ?, ?)
-			`, feedURL, name, string(usersJSON), channel)
-			if err != nil {
+			if feedID, err = res.LastInsertId(); err != nil {
 				return err
 			}
 
 			// 初始化 feed_data 记录
 			_, err = tx.Exec(`
-				INSERT INTO feed_data (rss_name, last_update_time) VALUES (?, CURRENT_TIMESTAMP)
-			`, name)
+				INSERT INTO feed_data (feed_id, last_update_time) VALUES (?, CURRENT_TIMESTAMP)
+			`, feedID)
 			if err != nil {
 				return err
 			}
 		} else if err != nil {
 			return err // 返回其他错误
 		} else {
-			// 订阅已存在，更新用户列表
-			var existingUsers []int64
-			if err := json.Unmarshal([]byte(existingUsersStr), &existingUsers); err != nil {
-				return err
-			}
-
 			// 检查用户是否已订阅
-			for _, uid := range existingUsers {
-				if uid == userID {
-					return fmt.Errorf("你已经订阅了这个RSS源")
-				}
-			}
-
-			// 添加用户到现有订阅
-			existingUsers = append(existingUsers, userID)
-			usersJSON, err := json.Marshal(existingUsers)
-			if err != nil {
+			var alias string
+			err = tx.QueryRow("SELECT alias FROM user_subscriptions WHERE user_id = ? AND feed_id = ?", userID, feedID).Scan(&alias)
+			if err == nil {
+				return fmt.Errorf("你已经以 \"%s\" 为名订阅了这个RSS源", alias)
+			} else if err != sql.ErrNoRows {
 				return err
 			}
 
-			_, err = tx.Exec("UPDATE subscriptions SET users = ? WHERE rss_url = ?", string(usersJSON), feedURL)
-			if err != nil {
+			// 检查间隔由同一RSS源的所有订阅者共享，不覆盖其他用户的设置
+			var current int
+			if err := tx.QueryRow("SELECT poll_interval FROM feeds WHERE feed_id = ?", feedID).Scan(&current); err != nil {
 				return err
 			}
+			if interval > 0 && interval != current {
+				currentText := fmt.Sprintf("%d 分钟", current)
+				if current <= 0 {
+					currentText = fmt.Sprintf("默认周期 %d 分钟", globalConfig.Cycletime)
+				}
+				notice = fmt.Sprintf("ℹ️ 该RSS源已有其他用户订阅，检查间隔由所有订阅者共享，当前为%s，本次指定的 %d 分钟未生效", currentText, interval)
+			}
+		}
+
+		// 添加用户到订阅
+		_, err = tx.Exec("INSERT INTO user_subscriptions (user_id, feed_id, alias, created_at) VALUES (?, ?, ?, ?)",
+			userID, feedID, name, time.Now().UTC().Format("2006-01-02 15:04:05"))
+		if err != nil {
+			return err
 		}
 
 		return tx.Commit()
 	})
+	return notice, err
 }
 
 func verifyRSSFeed(feedURL string) (bool, string) {
@@ -1810,61 +2098,83 @@ func verifyRSSFeed(feedURL string) (bool, string) {
 // RSS监控功能
 func startRSSMonitor() {
 	//logMessage("info", "RSS监控已启动")
-	ticker := time.NewTicker(time.Duration(globalConfig.Cycletime) * time.Minute)
+	ticker := time.NewTicker(SchedulerTick)
 	defer ticker.Stop()
-	db, err := sql.Open("sqlite3", "tgbot.db")
-	if err != nil {
-		logMessage("error", fmt.Sprintf("连接数据库失败: %v", err))
-		os.Exit(1)
+	client := createHTTPClient(globalConfig.ProxyURL)
+
+	// 上一轮检查未完成时跳过本轮，避免检查周期重叠
+	runCheck := func() {
+		if !rssCheckMutex.TryLock() {
+			logMessage("warn", "上一轮RSS检查尚未完成，跳过本轮")
+			return
+		}
+		defer rssCheckMutex.Unlock()
+		defer func() {
+			if r := recover(); r != nil {
+				logMessage("error", fmt.Sprintf("RSS监控发生panic: %v", r))
+			}
+		}()
+		checkAllRSS(db, client)
 	}
-	defer db.Close()
-	checkAllRSS(db)
-	logMessage("info", fmt.Sprintf("TGBot已启动，每%d分钟检查一次RSS", globalConfig.Cycletime))
+
+	// 启动时先投递上次未完成的推送
+	drainOutbox(db)
+	runCheck()
+	logMessage("info", fmt.Sprintf("TGBot已启动，默认每%d分钟检查一次RSS", globalConfig.Cycletime))
 	for {
 		select {
 		case <-ticker.C:
-			go func() {
-				defer func() {
-					if r := recover(); r != nil {
-						logMessage("error", fmt.Sprintf("RSS监控发生panic: %v", r))
-					}
-				}()
-				checkAllRSS(db)
-			}()
+			go drainOutbox(db)
+			go sendDueDigests(db)
+			go runCheck()
 		}
 	}
 }
 
-// splitMessage 将长文本分割成多个片段
+// splitMessage 将长文本按行分割成多个不超过maxLength字节的片段
+// 优先在换行处分割，保证HTML标签和链接不被截断；单行超长时按字符边界分割，不会截断多字节字符
 func splitMessage(text string, maxLength int) []string {
 	var chunks []string
-	// 文本过长时循环分割
-	for len(text) > maxLength {
-		chunk := text[:maxLength]
-		// 尝试在换行符处分割
-		lastNewline := strings.LastIndex(chunk, "\n")
-		if lastNewline != -1 && lastNewline > maxLength/2 {
-			// 在换行处分割
-			chunk = text[:lastNewline]
-			text = text[lastNewline+1:]
-		} else {
-			// 没有合适的换行符，直接按长度分割
-			text = text[maxLength:]
+	var current strings.Builder
+	flush := func() {
+		if chunk := strings.TrimRight(current.String(), "\n"); chunk != "" {
+			chunks = append(chunks, chunk)
 		}
-		chunks = append(chunks, chunk)
+		current.Reset()
 	}
 
-	// 添加剩余文本
-	if len(text) > 0 {
-		chunks = append(chunks, text)
-	}
+	for _, line := range strings.Split(text, "\n") {
+		// 单行超长，没有合适的换行符，按字符边界直接分割
+		for len(line) > maxLength {
+			flush()
+			cut := maxLength
+			for cut > 0 && !utf8.RuneStart(line[cut]) {
+				cut--
+			}
+			chunks = append(chunks, line[:cut])
+			line = line[cut:]
+		}
 
+		if current.Len() > 0 && current.Len()+1+len(line) > maxLength {
+			flush()
+		}
+		if current.Len() == 0 && line == "" {
+			continue // 片段开头的空行没有意义
+		}
+		if current.Len() > 0 {
+			current.WriteByte('\n')
+		}
+		current.WriteString(line)
+	}
+	flush()
 	return chunks
 }
-func sendother(message string) {
+
+// sendother 通过额外推送接口发送消息
+func sendother(message string) error {
 	// 使用全局配置而不是创建新的空指针
 	if globalConfig.Pushinfo == "" {
-		return
+		return nil
 	}
 	encodedInfo := url.QueryEscape(message)
 	tgURL := fmt.Sprintf(globalConfig.Pushinfo+"%s", encodedInfo)
@@ -1874,16 +2184,17 @@ func sendother(message string) {
 	resp, err := client.Get(tgURL)
 	if err != nil {
 		logMessage("error", fmt.Sprintf("推送消息失败: %v", err))
-		return
+		return err
 	}
 	defer resp.Body.Close()
 
 	body, _ := io.ReadAll(resp.Body)
 	if resp.StatusCode != http.StatusOK {
 		logMessage("error", fmt.Sprintf("推送消息失败, 状态码: %d, 响应内容: %s", resp.StatusCode, string(body)))
-		return
+		return fmt.Errorf("额外推送接口返回状态码 %d", resp.StatusCode)
 	}
 	logMessage("debug", fmt.Sprintf("成功推送，响应结果: %s", resp.Status))
+	return nil
 }
 
 type Asset struct {
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+// TestMain 在临时目录中运行测试，避免日志文件写入源码目录
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "tgbot-rss-test")
+	if err != nil {
+		panic(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		panic(err)
+	}
+	globalConfig = &Config{Cycletime: 1, HistoryDays: DefaultHistoryDays}
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
@@ -0,0 +1,741 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// migration 一个数据库结构版本的升级步骤
+type migration struct {
+	version int
+	name    string
+	apply   func(tx *sql.Tx) error
+}
+
+// migrations 按版本排列的全部迁移，只能在末尾追加，已发布的迁移不能修改
+// 早于迁移框架的数据库没有版本记录，前3个迁移需兼容已经存在的表和字段
+var migrations = []migration{
+	{version: 1, name: "创建基础表", apply: migrateBaseTables},
+	{version: 2, name: "feed_data增加条件请求缓存字段", apply: migrateFeedDataCache},
+	{version: 3, name: "订阅用户列表迁移到关系表", apply: migrateUserSubscriptions},
+	{version: 4, name: "按规范化URL合并订阅源并支持个人订阅名称", apply: migrateFeedAliases},
+	{version: 5, name: "创建条目历史表", apply: migrateItemHistory},
+	{version: 6, name: "支持摘要推送", apply: migrateDigest},
+	{version: 7, name: "支持用户时区和免打扰", apply: migrateQuietHours},
+	{version: 8, name: "订阅增加推送模板", apply: migrateTemplates},
+	{version: 9, name: "支持推送按钮和收藏", apply: migratePushActions},
+	{version: 10, name: "发件箱支持额外推送接口", apply: migrateOutboxTarget},
+	{version: 11, name: "摘要条目按链接去重", apply: migrateDigestUnique},
+}
+
+// queryer 可执行查询的数据库连接或事务
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// execer 可执行语句的数据库连接或事务
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// migrateSchema 按版本依次执行未应用的迁移，每个迁移在单独的事务中执行
+// 执行前备份数据库文件，数据库版本高于程序支持的版本时拒绝启动
+func migrateSchema(db *sql.DB) error {
+	var existingTables int
+	err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master
+		WHERE type = 'table' AND name NOT IN ('schema_version', 'sqlite_sequence')`).Scan(&existingTables)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS schema_version (
+		version INTEGER PRIMARY KEY,                       -- 版本号
+		name TEXT NOT NULL,                                -- 迁移说明
+		applied_at TEXT NOT NULL                           -- 应用时间
+	)`)
+	if err != nil {
+		return err
+	}
+
+	current, err := getSchemaVersion(db)
+	if err != nil {
+		return err
+	}
+	latest := migrations[len(migrations)-1].version
+	if current > latest {
+		return fmt.Errorf("数据库结构版本 %d 高于程序支持的版本 %d，请升级程序或使用备份的数据库", current, latest)
+	}
+	if current == latest {
+		logMessage("debug", fmt.Sprintf("数据库结构已是最新版本 %d", current))
+		return nil
+	}
+
+	// 已有数据的数据库先备份，全新数据库无需备份
+	if existingTables > 0 {
+		backup := fmt.Sprintf("%s.v%d-%s.bak", DBFile, current, time.Now().Format("20060102150405"))
+		if _, err := db.Exec("VACUUM INTO ?", backup); err != nil {
+			return fmt.Errorf("备份数据库失败: %v", err)
+		}
+		logMessage("info", fmt.Sprintf("数据库已备份到 %s", backup))
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		if err := applyMigration(db, m); err != nil {
+			return fmt.Errorf("执行迁移 %d(%s) 失败: %v", m.version, m.name, err)
+		}
+		logMessage("info", fmt.Sprintf("数据库结构已升级到版本 %d: %s", m.version, m.name))
+	}
+	return nil
+}
+
+// getSchemaVersion 返回已应用的最高迁移版本，未应用任何迁移时返回0
+func getSchemaVersion(db *sql.DB) (int, error) {
+	var version int
+	err := db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_version").Scan(&version)
+	return version, err
+}
+
+// applyMigration 在事务中执行迁移并记录版本
+// 迁移期间关闭外键约束以便重建表，提交前检查外键完整性
+func applyMigration(db *sql.DB, m migration) error {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF"); err != nil {
+		return err
+	}
+	defer conn.ExecContext(ctx, "PRAGMA foreign_keys = ON")
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := m.apply(tx); err != nil {
+		return err
+	}
+	if err := checkForeignKeys(tx); err != nil {
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO schema_version (version, name, applied_at) VALUES (?, ?, ?)",
+		m.version, m.name, time.Now().UTC().Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// checkForeignKeys 检查迁移后是否存在违反外键约束的记录
+func checkForeignKeys(tx *sql.Tx) error {
+	rows, err := tx.Query("PRAGMA foreign_key_check")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		var table, parent string
+		var rowID sql.NullInt64
+		var fkID int
+		if err := rows.Scan(&table, &rowID, &parent, &fkID); err != nil {
+			return err
+		}
+		return fmt.Errorf("表 %s 的记录 %d 引用了 %s 中不存在的数据", table, rowID.Int64, parent)
+	}
+	return rows.Err()
+}
+
+// execAll 依次执行多条语句
+func execAll(e execer, statements ...string) error {
+	for _, statement := range statements {
+		if _, err := e.Exec(statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateBaseTables 版本1：创建关键词、源数据、发件箱、过滤设置和去重表
+func migrateBaseTables(tx *sql.Tx) error {
+	return execAll(tx,
+		`CREATE TABLE IF NOT EXISTS user_keywords (
+			user_id INTEGER PRIMARY KEY,                       -- 用户ID
+			keywords TEXT NOT NULL DEFAULT '[]'               -- 关键词列表，JSON格式
+		)`,
+		`CREATE TABLE IF NOT EXISTS feed_data (
+			rss_name TEXT PRIMARY KEY,                         -- 订阅名称
+			last_update_time TEXT, -- 最后更新时间
+			latest_title TEXT DEFAULT ''                      -- 最新文章标题
+		)`,
+		`CREATE TABLE IF NOT EXISTS outbox (
+			outbox_id INTEGER PRIMARY KEY AUTOINCREMENT,       -- 发件箱记录ID
+			user_id INTEGER NOT NULL,                          -- 接收用户ID
+			subscription_id INTEGER NOT NULL,                  -- 订阅ID
+			rss_name TEXT NOT NULL DEFAULT '',                 -- 订阅名称
+			text TEXT NOT NULL,                                -- 渲染好的HTML消息
+			photo_url TEXT NOT NULL DEFAULT '',                -- 图片地址
+			attempts INTEGER NOT NULL DEFAULT 0,               -- 已投递次数
+			next_attempt TEXT NOT NULL,                        -- 下次投递时间
+			status TEXT NOT NULL DEFAULT 'pending',            -- 状态(pending/done/failed)
+			last_error TEXT NOT NULL DEFAULT '',               -- 最近一次错误
+			created_at TEXT NOT NULL                           -- 创建时间
+		)`,
+		`CREATE TABLE IF NOT EXISTS subscription_filters (
+			user_id INTEGER NOT NULL,                          -- 用户ID
+			subscription_id INTEGER NOT NULL,                  -- 订阅ID
+			mode TEXT NOT NULL DEFAULT 'inherit',              -- 过滤模式(inherit/all/custom)
+			keywords TEXT NOT NULL DEFAULT '[]',               -- 专属关键词列表，JSON格式
+			PRIMARY KEY (user_id, subscription_id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS seen_items (
+			subscription_id INTEGER NOT NULL,                  -- 订阅ID
+			item_key TEXT NOT NULL,                            -- 去重键(GUID/链接/内容哈希)
+			first_seen TEXT NOT NULL,                          -- 首次出现时间
+			last_seen TEXT NOT NULL,                           -- 最后出现时间
+			PRIMARY KEY (subscription_id, item_key)
+		)`,
+		"CREATE INDEX IF NOT EXISTS idx_feed_data_update_time ON feed_data(last_update_time)",
+		"CREATE INDEX IF NOT EXISTS idx_outbox_status_next ON outbox(status, next_attempt)",
+		"CREATE INDEX IF NOT EXISTS idx_seen_items_last_seen ON seen_items(last_seen)",
+	)
+}
+
+// migrateFeedDataCache 版本2：feed_data增加ETag和Last-Modified缓存
+func migrateFeedDataCache(tx *sql.Tx) error {
+	if err := addColumnIfMissing(tx, "feed_data", "etag", "TEXT DEFAULT ''"); err != nil {
+		return err
+	}
+	return addColumnIfMissing(tx, "feed_data", "last_modified", "TEXT DEFAULT ''")
+}
+
+// migrateUserSubscriptions 版本3：创建feeds/users/user_subscriptions并迁移旧版subscriptions表
+func migrateUserSubscriptions(tx *sql.Tx) error {
+	err := execAll(tx,
+		`CREATE TABLE IF NOT EXISTS feeds (
+			feed_id INTEGER PRIMARY KEY AUTOINCREMENT,         -- 订阅ID
+			rss_url TEXT NOT NULL,                             -- RSS源URL
+			rss_name TEXT NOT NULL UNIQUE,                     -- 订阅名称（唯一）
+			channel INTEGER DEFAULT 0,                         -- 是否为频道模式(0/1)
+			poll_interval INTEGER DEFAULT 0,                   -- 检查间隔(分钟)，0表示使用全局设置
+			consecutive_failures INTEGER DEFAULT 0,            -- 连续失败次数
+			last_error TEXT DEFAULT '',                        -- 最近一次错误
+			last_success TEXT DEFAULT '',                      -- 最近一次成功时间
+			last_status INTEGER DEFAULT 0,                     -- 最近一次HTTP状态码
+			paused INTEGER DEFAULT 0                           -- 是否已暂停(0/1)
+		)`,
+		`CREATE TABLE IF NOT EXISTS users (
+			user_id INTEGER PRIMARY KEY,                       -- 用户ID
+			created_at TEXT NOT NULL                           -- 首次使用时间
+		)`,
+		`CREATE TABLE IF NOT EXISTS user_subscriptions (
+			user_id INTEGER NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,  -- 用户ID
+			feed_id INTEGER NOT NULL REFERENCES feeds(feed_id) ON DELETE CASCADE,  -- 订阅ID
+			created_at TEXT NOT NULL,                                              -- 订阅时间
+			PRIMARY KEY (user_id, feed_id)
+		)`,
+		"CREATE INDEX IF NOT EXISTS idx_user_subscriptions_feed ON user_subscriptions(feed_id)",
+	)
+	if err != nil {
+		return err
+	}
+	return migrateLegacySubscriptions(tx)
+}
+
+// migrateFeedAliases 版本4：订阅源按规范化URL唯一，订阅名称改为每个用户单独设置
+// 同一URL的多个旧订阅合并为ID最小的一个，原订阅名称成为各订阅用户的个人名称，feed_data改为按订阅ID存储
+func migrateFeedAliases(tx *sql.Tx) error {
+	rows, err := tx.Query("SELECT feed_id, rss_url, rss_name, channel FROM feeds ORDER BY feed_id")
+	if err != nil {
+		return err
+	}
+	keep := make(map[string]int) // 规范化URL -> 保留的订阅ID
+	remap := make(map[int]int)   // 被合并的订阅ID -> 保留的订阅ID
+	var kept []int
+	canonical := make(map[int]string)
+	names := make(map[int]string)
+	channels := make(map[int]int)
+	for rows.Next() {
+		var id, channel int
+		var rawURL, name string
+		if err := rows.Scan(&id, &rawURL, &name, &channel); err != nil {
+			rows.Close()
+			return err
+		}
+		feedURL := canonicalFeedURL(rawURL)
+		if keptID, ok := keep[feedURL]; ok {
+			remap[id] = keptID
+			// 频道模式按订阅源保存，合并后统一使用保留订阅的设置
+			if channel != channels[keptID] {
+				logMessage("warn", fmt.Sprintf("订阅 %s 与 %s 的URL相同但频道模式不同，合并后统一使用 %s 的设置(频道模式=%d)",
+					name, names[keptID], names[keptID], channels[keptID]))
+			}
+			continue
+		}
+		keep[feedURL] = id
+		canonical[id] = feedURL
+		names[id] = name
+		channels[id] = channel
+		kept = append(kept, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	err = execAll(tx,
+		`CREATE TABLE feeds_new (
+			feed_id INTEGER PRIMARY KEY AUTOINCREMENT,         -- 订阅ID
+			rss_url TEXT NOT NULL UNIQUE,                      -- 规范化后的RSS源URL（唯一）
+			rss_name TEXT NOT NULL DEFAULT '',                 -- 首个订阅者设置的名称，用于日志
+			channel INTEGER DEFAULT 0,                         -- 是否为频道模式(0/1)
+			poll_interval INTEGER DEFAULT 0,                   -- 检查间隔(分钟)，0表示使用全局设置
+			consecutive_failures INTEGER DEFAULT 0,            -- 连续失败次数
+			last_error TEXT DEFAULT '',                        -- 最近一次错误
+			last_success TEXT DEFAULT '',                      -- 最近一次成功时间
+			last_status INTEGER DEFAULT 0,                     -- 最近一次HTTP状态码
+			paused INTEGER DEFAULT 0                           -- 是否已暂停(0/1)
+		)`,
+		`CREATE TABLE user_subscriptions_new (
+			user_id INTEGER NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,  -- 用户ID
+			feed_id INTEGER NOT NULL REFERENCES feeds(feed_id) ON DELETE CASCADE,  -- 订阅ID
+			alias TEXT NOT NULL,                                                   -- 用户为订阅设置的名称
+			created_at TEXT NOT NULL,                                              -- 订阅时间
+			PRIMARY KEY (user_id, feed_id),
+			UNIQUE (user_id, alias)
+		)`,
+		`CREATE TABLE feed_data_new (
+			feed_id INTEGER PRIMARY KEY REFERENCES feeds(feed_id) ON DELETE CASCADE,  -- 订阅ID
+			last_update_time TEXT,                                                   -- 最后更新时间
+			latest_title TEXT DEFAULT '',                                            -- 最新文章标题
+			etag TEXT DEFAULT '',                                                    -- ETag缓存
+			last_modified TEXT DEFAULT ''                                            -- Last-Modified缓存
+		)`,
+	)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range kept {
+		_, err := tx.Exec(`INSERT INTO feeds_new (feed_id, rss_url, rss_name, channel, poll_interval, consecutive_failures,
+			last_error, last_success, last_status, paused)
+			SELECT feed_id, ?, rss_name, channel, poll_interval, consecutive_failures, last_error, last_success, last_status, paused
+			FROM feeds WHERE feed_id = ?`, canonical[id], id)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := migrateSubscriptionAliases(tx, remap); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT OR IGNORE INTO feed_data_new (feed_id, last_update_time, latest_title, etag, last_modified)
+		SELECT f.feed_id, d.last_update_time, d.latest_title, d.etag, d.last_modified
+		FROM feed_data d JOIN feeds f ON f.rss_name = d.rss_name ORDER BY f.feed_id`)
+	if err != nil {
+		return err
+	}
+
+	// 被合并订阅的过滤设置、去重记录和发件箱指向保留的订阅
+	for from, to := range remap {
+		for _, query := range []string{
+			"UPDATE OR IGNORE subscription_filters SET subscription_id = ? WHERE subscription_id = ?",
+			"UPDATE OR IGNORE seen_items SET subscription_id = ? WHERE subscription_id = ?",
+			"UPDATE OR IGNORE feed_data_new SET feed_id = ? WHERE feed_id = ?",
+			"UPDATE outbox SET subscription_id = ? WHERE subscription_id = ?",
+		} {
+			if _, err := tx.Exec(query, to, from); err != nil {
+				return err
+			}
+		}
+		for _, query := range []string{
+			"DELETE FROM subscription_filters WHERE subscription_id = ?",
+			"DELETE FROM seen_items WHERE subscription_id = ?",
+			"DELETE FROM feed_data_new WHERE feed_id = ?",
+		} {
+			if _, err := tx.Exec(query, from); err != nil {
+				return err
+			}
+		}
+	}
+	if len(remap) > 0 {
+		logMessage("info", fmt.Sprintf("已合并 %d 个重复URL的订阅", len(remap)))
+	}
+
+	return execAll(tx,
+		"DROP TABLE user_subscriptions",
+		"DROP TABLE feed_data",
+		"DROP TABLE feeds",
+		"ALTER TABLE feeds_new RENAME TO feeds",
+		"ALTER TABLE user_subscriptions_new RENAME TO user_subscriptions",
+		"ALTER TABLE feed_data_new RENAME TO feed_data",
+		"CREATE INDEX IF NOT EXISTS idx_user_subscriptions_feed ON user_subscriptions(feed_id)",
+		"CREATE INDEX IF NOT EXISTS idx_feed_data_update_time ON feed_data(last_update_time)",
+	)
+}
+
+// migrateSubscriptionAliases 将订阅关系写入user_subscriptions_new，旧订阅名称成为用户的个人名称
+// 旧订阅名称全局唯一，不会冲突；用户订阅了被合并的多个旧订阅时保留ID最小的名称，
+// 并把该用户关键词中 +其他名称 的RSS过滤改为保留的名称，避免合并后关键词失效
+func migrateSubscriptionAliases(tx *sql.Tx, remap map[int]int) error {
+	rows, err := tx.Query(`SELECT us.user_id, us.feed_id, f.rss_name, us.created_at
+		FROM user_subscriptions us JOIN feeds f ON f.feed_id = us.feed_id ORDER BY us.feed_id`)
+	if err != nil {
+		return err
+	}
+	type subscriptionKey struct {
+		userID int64
+		feedID int
+	}
+	type pendingSubscription struct {
+		key              subscriptionKey
+		alias, createdAt string
+	}
+	type aliasRename struct {
+		userID   int64
+		from, to string
+	}
+	var inserts []pendingSubscription
+	aliases := make(map[subscriptionKey]string)
+	var renames []aliasRename
+	for rows.Next() {
+		var key subscriptionKey
+		var alias, createdAt string
+		if err := rows.Scan(&key.userID, &key.feedID, &alias, &createdAt); err != nil {
+			rows.Close()
+			return err
+		}
+		if to, ok := remap[key.feedID]; ok {
+			key.feedID = to
+		}
+		if keptAlias, ok := aliases[key]; ok {
+			renames = append(renames, aliasRename{userID: key.userID, from: alias, to: keptAlias})
+			continue
+		}
+		aliases[key] = alias
+		inserts = append(inserts, pendingSubscription{key: key, alias: alias, createdAt: createdAt})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, s := range inserts {
+		_, err := tx.Exec("INSERT INTO user_subscriptions_new (user_id, feed_id, alias, created_at) VALUES (?, ?, ?, ?)",
+			s.key.userID, s.key.feedID, s.alias, s.createdAt)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, r := range renames {
+		if err := renameKeywordRSSName(tx, r.userID, r.from, r.to); err != nil {
+			return err
+		}
+		logMessage("info", fmt.Sprintf("订阅 %s 已合并到 %s，关键词中的RSS过滤已同步修改", r.from, r.to), r.userID)
+	}
+	return nil
+}
+
+// renameKeywordRSSName 将用户全局关键词和订阅专属关键词中 +from 的RSS过滤改为 +to
+func renameKeywordRSSName(tx *sql.Tx, userID int64, from, to string) error {
+	rename := func(keywordsStr string) (string, bool) {
+		keywords := parseKeywords(keywordsStr)
+		changed := false
+		for i, keyword := range keywords {
+			rule, err := parseKeywordRule(keyword)
+			if err != nil || !strings.EqualFold(rule.RSSName, from) {
+				continue
+			}
+			keywords[i] = keyword[:strings.LastIndex(keyword, "+")+1] + to
+			changed = true
+		}
+		if !changed {
+			return keywordsStr, false
+		}
+		data, _ := json.Marshal(keywords)
+		return string(data), true
+	}
+
+	var keywordsStr string
+	err := tx.QueryRow("SELECT keywords FROM user_keywords WHERE user_id = ?", userID).Scan(&keywordsStr)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if renamed, ok := rename(keywordsStr); ok {
+		if _, err := tx.Exec("UPDATE user_keywords SET keywords = ? WHERE user_id = ?", renamed, userID); err != nil {
+			return err
+		}
+	}
+
+	rows, err := tx.Query("SELECT subscription_id, keywords FROM subscription_filters WHERE user_id = ?", userID)
+	if err != nil {
+		return err
+	}
+	updates := make(map[int]string)
+	for rows.Next() {
+		var subscriptionID int
+		if err := rows.Scan(&subscriptionID, &keywordsStr); err != nil {
+			rows.Close()
+			return err
+		}
+		if renamed, ok := rename(keywordsStr); ok {
+			updates[subscriptionID] = renamed
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for subscriptionID, keywords := range updates {
+		_, err := tx.Exec("UPDATE subscription_filters SET keywords = ? WHERE user_id = ? AND subscription_id = ?",
+			keywords, userID, subscriptionID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateItemHistory 版本5：保存抓取到的全部条目，用于搜索和关键词测试
+func migrateItemHistory(tx *sql.Tx) error {
+	return execAll(tx,
+		`CREATE TABLE item_history (
+			item_id INTEGER PRIMARY KEY AUTOINCREMENT,                               -- 条目ID
+			feed_id INTEGER NOT NULL REFERENCES feeds(feed_id) ON DELETE CASCADE,   -- 订阅ID
+			item_key TEXT NOT NULL,                                                  -- 去重键
+			title TEXT NOT NULL DEFAULT '',                                          -- 标题
+			link TEXT NOT NULL DEFAULT '',                                           -- 链接
+			description TEXT NOT NULL DEFAULT '',                                    -- 描述
+			content TEXT NOT NULL DEFAULT '',                                        -- 正文
+			author TEXT NOT NULL DEFAULT '',                                         -- 作者
+			categories TEXT NOT NULL DEFAULT '[]',                                   -- 分类，JSON格式
+			published_at TEXT NOT NULL,                                              -- 发布时间
+			fetched_at TEXT NOT NULL,                                                -- 首次抓取时间
+			UNIQUE (feed_id, item_key)
+		)`,
+		"CREATE INDEX idx_item_history_published ON item_history(published_at)",
+		"CREATE INDEX idx_item_history_fetched ON item_history(fetched_at)",
+	)
+}
+
+// migrateDigest 版本6：订阅增加推送方式，用户增加摘要推送时间，创建待推送摘要表
+func migrateDigest(tx *sql.Tx) error {
+	return execAll(tx,
+		"ALTER TABLE user_subscriptions ADD COLUMN delivery TEXT NOT NULL DEFAULT 'instant'",
+		"ALTER TABLE users ADD COLUMN digest_schedule TEXT NOT NULL DEFAULT ''",
+		"ALTER TABLE users ADD COLUMN digest_sent_at TEXT NOT NULL DEFAULT ''",
+		`CREATE TABLE digest_items (
+			digest_item_id INTEGER PRIMARY KEY AUTOINCREMENT,                        -- 摘要条目ID
+			user_id INTEGER NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,    -- 用户ID
+			feed_id INTEGER NOT NULL REFERENCES feeds(feed_id) ON DELETE CASCADE,    -- 订阅ID
+			title TEXT NOT NULL DEFAULT '',                                          -- 标题
+			link TEXT NOT NULL DEFAULT '',                                           -- 链接
+			keywords TEXT NOT NULL DEFAULT '[]',                                     -- 命中的关键词，JSON格式
+			published_at TEXT NOT NULL,                                              -- 发布时间
+			created_at TEXT NOT NULL                                                 -- 加入摘要的时间
+		)`,
+		"CREATE INDEX idx_digest_items_user ON digest_items(user_id, feed_id)",
+	)
+}
+
+// migrateQuietHours 版本7：用户增加时区和免打扰设置，发件箱增加静音推送标记
+func migrateQuietHours(tx *sql.Tx) error {
+	return execAll(tx,
+		"ALTER TABLE users ADD COLUMN timezone TEXT NOT NULL DEFAULT ''",
+		"ALTER TABLE users ADD COLUMN quiet_hours TEXT NOT NULL DEFAULT ''",
+		"ALTER TABLE users ADD COLUMN quiet_mode TEXT NOT NULL DEFAULT 'off'",
+		"ALTER TABLE outbox ADD COLUMN silent INTEGER NOT NULL DEFAULT 0",
+	)
+}
+
+// migrateTemplates 版本8：用户订阅增加自定义推送模板
+func migrateTemplates(tx *sql.Tx) error {
+	return execAll(tx, "ALTER TABLE user_subscriptions ADD COLUMN template TEXT NOT NULL DEFAULT ''")
+}
+
+// migratePushActions 版本9：记录推送供按钮操作使用，订阅增加临时屏蔽，创建收藏表
+func migratePushActions(tx *sql.Tx) error {
+	return execAll(tx,
+		"ALTER TABLE user_subscriptions ADD COLUMN muted_until TEXT NOT NULL DEFAULT ''",
+		"ALTER TABLE outbox ADD COLUMN reply_markup TEXT NOT NULL DEFAULT ''",
+		`CREATE TABLE pushed_items (
+			pushed_item_id INTEGER PRIMARY KEY AUTOINCREMENT,                        -- 推送ID
+			user_id INTEGER NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,    -- 用户ID
+			feed_id INTEGER NOT NULL REFERENCES feeds(feed_id) ON DELETE CASCADE,    -- 订阅ID
+			feed_name TEXT NOT NULL DEFAULT '',                                      -- 推送时的订阅名称
+			title TEXT NOT NULL DEFAULT '',                                          -- 标题
+			link TEXT NOT NULL DEFAULT '',                                           -- 链接
+			description TEXT NOT NULL DEFAULT '',                                    -- 描述摘要
+			keywords TEXT NOT NULL DEFAULT '[]',                                     -- 命中的原始关键词，JSON格式
+			published_at TEXT NOT NULL,                                              -- 发布时间
+			created_at TEXT NOT NULL                                                 -- 推送时间
+		)`,
+		"CREATE INDEX idx_pushed_items_created ON pushed_items(created_at)",
+		`CREATE TABLE saved_items (
+			saved_item_id INTEGER PRIMARY KEY AUTOINCREMENT,                         -- 收藏ID
+			user_id INTEGER NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,    -- 用户ID
+			feed_name TEXT NOT NULL DEFAULT '',                                      -- 订阅名称
+			title TEXT NOT NULL DEFAULT '',                                          -- 标题
+			link TEXT NOT NULL DEFAULT '',                                           -- 链接
+			description TEXT NOT NULL DEFAULT '',                                    -- 描述摘要
+			published_at TEXT NOT NULL,                                              -- 发布时间
+			saved_at TEXT NOT NULL                                                   -- 收藏时间
+		)`,
+		"CREATE INDEX idx_saved_items_user ON saved_items(user_id, saved_at)",
+	)
+}
+
+// migrateOutboxTarget 版本10：发件箱增加投递目标，额外推送接口的消息也先持久化再发送
+func migrateOutboxTarget(tx *sql.Tx) error {
+	return execAll(tx, "ALTER TABLE outbox ADD COLUMN target TEXT NOT NULL DEFAULT ''")
+}
+
+// migrateDigestUnique 版本11：同一用户同一订阅的摘要条目按链接唯一，重新处理的条目不会重复加入摘要
+// 没有链接的条目无法判断是否重复，不做限制
+func migrateDigestUnique(tx *sql.Tx) error {
+	return execAll(tx,
+		`DELETE FROM digest_items WHERE link != '' AND digest_item_id NOT IN (
+			SELECT MIN(digest_item_id) FROM digest_items WHERE link != '' GROUP BY user_id, feed_id, link
+		)`,
+		"CREATE UNIQUE INDEX idx_digest_items_unique ON digest_items(user_id, feed_id, link) WHERE link != ''",
+	)
+}
+
+// legacySubscription 旧版subscriptions表中的一行
+type legacySubscription struct {
+	id                                   int
+	url, name, users, lastError, success string
+	channel, interval, failures, status  int
+	paused                               int
+}
+
+// migrateLegacySubscriptions 将旧版subscriptions表迁移到feeds/users/user_subscriptions
+// 用户列表兼容JSON数组和",id,id,"两种旧格式，订阅ID保持不变，去重记录和发件箱无需修改
+func migrateLegacySubscriptions(tx *sql.Tx) error {
+	columns, err := tableColumns(tx, "subscriptions")
+	if err != nil {
+		return err
+	}
+	if len(columns) == 0 {
+		return nil // 全新数据库，没有旧表
+	}
+
+	// 较早版本的旧表可能缺少部分字段，缺少时使用默认值
+	column := func(name, fallback string) string {
+		if columns[name] {
+			return fmt.Sprintf("COALESCE(%s, %s)", name, fallback)
+		}
+		return fallback
+	}
+	query := fmt.Sprintf(`SELECT subscription_id, rss_url, rss_name, COALESCE(users, ''), %s, %s, %s, %s, %s, %s, %s
+		FROM subscriptions`,
+		column("channel", "0"), column("poll_interval", "0"), column("consecutive_failures", "0"),
+		column("last_error", "''"), column("last_success", "''"), column("last_status", "0"), column("paused", "0"))
+
+	rows, err := tx.Query(query)
+	if err != nil {
+		return err
+	}
+	var legacy []legacySubscription
+	for rows.Next() {
+		var s legacySubscription
+		if err := rows.Scan(&s.id, &s.url, &s.name, &s.users, &s.channel, &s.interval, &s.failures,
+			&s.lastError, &s.success, &s.status, &s.paused); err != nil {
+			rows.Close()
+			return err
+		}
+		legacy = append(legacy, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	now := time.Now().UTC().Format("2006-01-02 15:04:05")
+	for _, s := range legacy {
+		_, err := tx.Exec(`INSERT INTO feeds (feed_id, rss_url, rss_name, channel, poll_interval, consecutive_failures,
+			last_error, last_success, last_status, paused) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			s.id, s.url, s.name, s.channel, s.interval, s.failures, s.lastError, s.success, s.status, s.paused)
+		if err != nil {
+			return err
+		}
+
+		for _, userID := range parseUserIDs(s.users) {
+			if err := ensureUser(tx, userID); err != nil {
+				return err
+			}
+			if _, err := tx.Exec("INSERT OR IGNORE INTO user_subscriptions (user_id, feed_id, created_at) VALUES (?, ?, ?)",
+				userID, s.id, now); err != nil {
+				return err
+			}
+		}
+	}
+
+	// 只设置了关键词的用户也写入用户表
+	if _, err := tx.Exec("INSERT OR IGNORE INTO users (user_id, created_at) SELECT user_id, ? FROM user_keywords", now); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DROP TABLE subscriptions"); err != nil {
+		return err
+	}
+	logMessage("info", fmt.Sprintf("已迁移 %d 个旧版订阅", len(legacy)))
+	return nil
+}
+
+// ensureUser 确保用户存在于用户表中
+func ensureUser(e execer, userID int64) error {
+	_, err := e.Exec("INSERT OR IGNORE INTO users (user_id, created_at) VALUES (?, ?)",
+		userID, time.Now().UTC().Format("2006-01-02 15:04:05"))
+	return err
+}
+
+// tableColumns 返回表的字段集合，表不存在时返回空集合
+func tableColumns(q queryer, table string) (map[string]bool, error) {
+	rows, err := q.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			return nil, err
+		}
+		columns[name] = true
+	}
+	return columns, rows.Err()
+}
+
+// addColumnIfMissing 如果表中不存在指定字段则添加
+func addColumnIfMissing(tx *sql.Tx, table, column, definition string) error {
+	columns, err := tableColumns(tx, table)
+	if err != nil {
+		return err
+	}
+	if columns[column] {
+		return nil
+	}
+
+	_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	if err == nil {
+		logMessage("info", fmt.Sprintf("数据库字段 %s.%s 已添加", table, column))
+	}
+	return err
+}
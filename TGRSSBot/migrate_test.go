@@ -0,0 +1,150 @@
+package main
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+)
+
+// legacySchema 迁移框架之前版本的数据库结构和数据
+var legacySchema = []string{
+	`CREATE TABLE subscriptions (
+		subscription_id INTEGER PRIMARY KEY AUTOINCREMENT,
+		rss_url TEXT NOT NULL,
+		rss_name TEXT NOT NULL UNIQUE,
+		users TEXT NOT NULL DEFAULT ',',
+		channel INTEGER DEFAULT 0
+	)`,
+	`CREATE TABLE user_keywords (
+		user_id INTEGER PRIMARY KEY,
+		keywords TEXT NOT NULL DEFAULT '[]'
+	)`,
+	`CREATE TABLE feed_data (
+		rss_name TEXT PRIMARY KEY,
+		last_update_time TEXT,
+		latest_title TEXT DEFAULT ''
+	)`,
+	`INSERT INTO subscriptions (subscription_id, rss_url, rss_name, users, channel) VALUES
+		(1, 'https://example.com/feed', '科技', ',100,200,', 0),
+		(2, 'https://Example.com:443/feed#top', '科技2', '[200,300]', 1),
+		(3, 'https://t.me/s/news', '频道', ',300,', 1)`,
+	`INSERT INTO user_keywords (user_id, keywords) VALUES
+		(200, '["显卡+科技2","re:/a+b/+科技2","4090","C++"]'),
+		(400, '["苹果"]')`,
+	`INSERT INTO feed_data (rss_name, last_update_time, latest_title) VALUES
+		('科技', '2024-01-01 00:00:00', '旧标题'),
+		('科技2', '2024-01-02 00:00:00', '旧标题2')`,
+}
+
+func TestMigrateLegacyDatabase(t *testing.T) {
+	db, err := sql.Open("sqlite3", t.TempDir()+"/legacy.db?_foreign_keys=on")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := execAll(db, legacySchema...); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := migrateSchema(db); err != nil {
+		t.Fatalf("migrateSchema: %v", err)
+	}
+	version, err := getSchemaVersion(db)
+	if err != nil || version != migrations[len(migrations)-1].version {
+		t.Fatalf("schema version = %d, %v, want %d", version, err, migrations[len(migrations)-1].version)
+	}
+	if columns, _ := tableColumns(db, "subscriptions"); len(columns) != 0 {
+		t.Error("legacy subscriptions table was not dropped")
+	}
+
+	// 同一URL的两个旧订阅合并为ID最小的一个，频道模式沿用保留订阅的设置
+	rows, err := db.Query("SELECT feed_id || ' ' || rss_url || ' ' || channel FROM feeds ORDER BY feed_id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	feeds := scanStrings(t, rows)
+	wantFeeds := []string{"1 https://example.com/feed 0", "3 https://t.me/s/news 1"}
+	if !reflect.DeepEqual(feeds, wantFeeds) {
+		t.Errorf("feeds = %q, want %q", feeds, wantFeeds)
+	}
+
+	// 旧订阅名称成为个人名称，同时订阅两个重复源的用户保留ID最小的名称
+	rows, err = db.Query("SELECT user_id || ' ' || feed_id || ' ' || alias FROM user_subscriptions ORDER BY user_id, feed_id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	subscriptions := scanStrings(t, rows)
+	wantSubscriptions := []string{"100 1 科技", "200 1 科技", "300 1 科技2", "300 3 频道"}
+	if !reflect.DeepEqual(subscriptions, wantSubscriptions) {
+		t.Errorf("user_subscriptions = %q, want %q", subscriptions, wantSubscriptions)
+	}
+
+	// 被合并名称的RSS过滤改为保留的名称，其他关键词不变
+	var keywords string
+	if err := db.QueryRow("SELECT keywords FROM user_keywords WHERE user_id = 200").Scan(&keywords); err != nil {
+		t.Fatal(err)
+	}
+	wantKeywords := []string{"显卡+科技", "re:/a+b/+科技", "4090", "C++"}
+	if got := parseKeywords(keywords); !reflect.DeepEqual(got, wantKeywords) {
+		t.Errorf("user 200 keywords = %q, want %q", got, wantKeywords)
+	}
+
+	// 只设置了关键词的用户也写入用户表
+	var users int
+	if err := db.QueryRow("SELECT COUNT(*) FROM users").Scan(&users); err != nil || users != 4 {
+		t.Errorf("users = %d, %v, want 4", users, err)
+	}
+
+	var title string
+	if err := db.QueryRow("SELECT latest_title FROM feed_data WHERE feed_id = 1").Scan(&title); err != nil || title != "旧标题" {
+		t.Errorf("feed_data title = %q, %v, want 旧标题", title, err)
+	}
+
+	// 已是最新版本时再次执行不做任何修改
+	if err := migrateSchema(db); err != nil {
+		t.Errorf("second migrateSchema: %v", err)
+	}
+}
+
+func TestMigrateNewDatabase(t *testing.T) {
+	db, err := sql.Open("sqlite3", t.TempDir()+"/new.db?_foreign_keys=on")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := migrateSchema(db); err != nil {
+		t.Fatalf("migrateSchema: %v", err)
+	}
+	for _, table := range []string{"feeds", "users", "user_subscriptions", "feed_data", "outbox", "item_history"} {
+		if columns, err := tableColumns(db, table); err != nil || len(columns) == 0 {
+			t.Errorf("table %s missing after migration: %v", table, err)
+		}
+	}
+
+	// 数据库版本高于程序支持的版本时拒绝启动
+	if _, err := db.Exec("INSERT INTO schema_version (version, name, applied_at) VALUES (999, 'future', '')"); err != nil {
+		t.Fatal(err)
+	}
+	if err := migrateSchema(db); err == nil {
+		t.Error("migrateSchema accepted a newer schema version")
+	}
+}
+
+// scanStrings 读取单列字符串结果
+func scanStrings(t *testing.T, rows *sql.Rows) []string {
+	t.Helper()
+	defer rows.Close()
+	var values []string
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			t.Fatal(err)
+		}
+		values = append(values, value)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return values
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+	"golang.org/x/text/width"
+)
+
+// ExactKeywordPrefix 精确匹配前缀，关键词和内容都只转小写，不做规范化处理
+const ExactKeywordPrefix = "="
+
+// 规范化时保留的标点，这些符号常作为关键词的一部分(如 C#、50%、AT&T)
+const keptPunctuation = "#%&@"
+
+// foldText 匹配前的文本折叠，保留空白和标点，供整词匹配和数值条件使用
+// 依次进行全角转半角、NFKC规范化、去除零宽等格式字符、大小写折叠，可选繁体转简体
+func foldText(text string) string {
+	text = norm.NFKC.String(width.Fold.String(text))
+	foldChinese := globalConfig != nil && globalConfig.FoldChinese
+
+	var b strings.Builder
+	b.Grow(len(text))
+	for _, r := range text {
+		if unicode.Is(unicode.Cf, r) {
+			continue
+		}
+		if foldChinese {
+			if simplified, ok := traditionalToSimplified[r]; ok {
+				r = simplified
+			}
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+// normalizeText 在折叠的基础上去掉空白和标点，用于普通关键词匹配
+func normalizeText(text string) string {
+	return collapseText(foldText(text))
+}
+
+// collapseText 去掉已折叠文本中的空白和标点
+func collapseText(folded string) string {
+	var b strings.Builder
+	b.Grow(len(folded))
+	for _, r := range folded {
+		if unicode.IsSpace(r) || (unicode.IsPunct(r) && !strings.ContainsRune(keptPunctuation, r)) {
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// traditionalSimplifiedPairs 繁简对照表，每项为"繁体简体"两个字，以空白分隔
+// 只收录商品、数码、新闻等RSS标题中的常用字，属于一对一的字形转换，不处理词汇差异(如 軟體→软件)；
+// 一简对多繁的字(如 復/複→复、臺/檯/颱→台)各占一项，每个繁体字只能出现一次
+const traditionalSimplifiedPairs = `
+顯显 體体 國国 學学 說说 話话 語语 讀读 書书 車车 電电 腦脑 機机 開开 關关 門门 問问 間间 閱阅 長长
+東东 會会 個个 們们 來来 時时 後后 發发 現现 實实 際际 經经 濟济 價价 錢钱 買买 賣卖 貨货 費费 貴贵
+質质 資资 貼贴 購购 優优 廣广 號号 碼码 網网 絡络 線线 終终 級级 紅红 綠绿 藍蓝 黃黄 與与 為为 這这
+樣样 對对 進进 還还 過过 邊边 選选 運运 動动 務务 勞劳 區区 醫医 藥药 報报 紙纸 錄录 鏡镜 鐵铁 銀银
+銷销 鍵键 盤盘 點点 熱热 無无 見见 視视 親亲 覺觉 觀观 計计 訂订 認认 設设 許许 論论 證证 評评 試试
+詢询 請请 謝谢 讓让 議议 護护 變变 貓猫 雞鸡 魚鱼 鳥鸟 馬马 驗验 頁页 頭头 題题 顏颜 類类 風风 飛飞
+飯饭 館馆 韓韩 漢汉 華华 歐欧 準准 處处 筆笔 節节 範范 圖图 團团 園园 場场 壞坏 聲声 賽赛 戰战 專专
+將将 導导 層层 歲岁 樂乐 歷历 當当 從从 復复 複复 總总 戀恋 應应 態态 愛爱 憂忧 擇择 據据 擊击 換换
+損损 標标 檢检 權权 條条 歡欢 氣气 決决 沒没 測测 滿满 潔洁 燈灯 爭争 獎奖 環环 產产 畫画 畢毕 確确
+禮礼 種种 穩稳 積积 窮穷 競竞 簡简 糧粮 純纯 紀纪 約约 紹绍 組组 細细 結结 給给 統统 絲丝 維维 綜综
+緊紧 編编 練练 織织 義义 習习 聯联 聽听 職职 膠胶 舊旧 興兴 舉举 莊庄 蘋苹 萬万 葉叶 藝艺 蟲虫 衛卫
+裝装 規规 覽览 訊讯 記记 講讲 負负 責责 財财 貧贫 賬账 賺赚 贈赠 贏赢 躍跃 軟软 較较 載载 輕轻 輸输
+辦办 遊游 達达 遠远 適适 遲迟 鄉乡 鄰邻 鬧闹 閃闪 閉闭 陣阵 陸陆 陰阴 陳陈 隊队 隨随 險险 隱隐 雜杂
+雙双 雖虽 難难 離离 雲云 靈灵 響响 須须 預预 領领 頻频 額额 願愿 飲饮 餘余 驅驱 髮发 鬥斗 鮮鲜 麗丽
+麥麦 黨党 齊齐 齒齿 龍龙 龜龟 劃划 劇剧 創创 勝胜 協协 單单 衝冲 側侧 債债 傷伤 傳传 僅仅 儲储 兒儿
+內内 兩两 冊册 凍冻 劍剑 勵励 勢势 匯汇 彙汇 參参 嚴严 圓圆 壓压 塊块 壽寿 夠够 夢梦 奪夺 奮奋 婦妇
+孫孙 寫写 寶宝 屬属 島岛 帳帐 帶带 幣币 幫帮 庫库 廠厂 廳厅 彈弹 徑径 憶忆 懷怀 戲戏 戶户 掃扫 掛挂
+擁拥 擔担 擴扩 攝摄 敗败 數数 斷断 於于 暫暂 曆历 極极 構构 槍枪 樓楼 橋桥 殺杀 殼壳 毀毁 漲涨 灣湾
+災灾 煙烟 爺爷 牆墙 狀状 獨独 獲获 療疗 盜盗 盡尽 眾众 礦矿 礙碍 稅税 竊窃 築筑 簽签 緒绪 繼继 續续
+罰罚 羅罗 聖圣 腳脚 膽胆 臉脸 艦舰 蘇苏 補补 訪访 詞词 該该 誤误 調调 談谈 諾诺 貝贝 貿贸 賀贺 賞赏
+賓宾 趨趋 輛辆 輪轮 轉转 辭辞 農农 遞递 遺遗 郵邮 醜丑 釋释 針针 鈔钞 鋼钢 錯错 鍋锅 鎖锁 鐘钟 錶表
+閒闲 陽阳 隻只 靜静 頂顶 順顺 頓顿 顧顾 飽饱 駕驾 騎骑 驚惊 鬆松 鹽盐 麵面 臺台 檯台 颱台 儀仪 螢萤
+搶抢 乾干 幹干 裏里 裡里 麼么 嗎吗 啟启 兌兑 剛刚 聞闻 週周 員员 識识
+`
+
+// traditionalToSimplified 繁体字到简体字的映射，由 traditionalSimplifiedPairs 构建
+var traditionalToSimplified = buildCharMap(traditionalSimplifiedPairs)
+
+// buildCharMap 解析以空格分隔的两字对照表
+func buildCharMap(pairs string) map[rune]rune {
+	m := make(map[rune]rune)
+	for _, pair := range strings.Fields(pairs) {
+		runes := []rune(pair)
+		if len(runes) == 2 {
+			m[runes[0]] = runes[1]
+		}
+	}
+	return m
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTraditionalSimplifiedPairs(t *testing.T) {
+	seen := make(map[rune]string)
+	for _, pair := range strings.Fields(traditionalSimplifiedPairs) {
+		runes := []rune(pair)
+		if len(runes) != 2 {
+			t.Errorf("pair %q is not two characters", pair)
+			continue
+		}
+		if prev, ok := seen[runes[0]]; ok {
+			t.Errorf("traditional character %c repeated: %q and %q", runes[0], prev, pair)
+		}
+		if runes[0] == runes[1] {
+			t.Errorf("pair %q maps a character to itself", pair)
+		}
+		seen[runes[0]] = pair
+	}
+	if len(seen) != len(traditionalToSimplified) {
+		t.Errorf("traditionalToSimplified has %d entries, want %d", len(traditionalToSimplified), len(seen))
+	}
+}
+
+func TestFoldText(t *testing.T) {
+	tests := []struct {
+		text        string
+		foldChinese bool
+		want        string
+	}{
+		{"ＲＴＸ－４０９０", false, "rtx-4090"},
+		{"Hello World", false, "hello world"},
+		{"零\u200b宽\u200d字符", false, "零宽字符"},
+		{"ｶﾀｶﾅ", false, "カタカナ"},
+		{"①②", false, "12"},
+		{"顯卡 價格", false, "顯卡 價格"},
+		{"顯卡 價格", true, "显卡 价格"},
+		{"軟體", true, "软体"},
+	}
+	defer func(fold bool) { globalConfig.FoldChinese = fold }(globalConfig.FoldChinese)
+	for _, tt := range tests {
+		globalConfig.FoldChinese = tt.foldChinese
+		if got := foldText(tt.text); got != tt.want {
+			t.Errorf("foldText(%q, chinese=%v) = %q, want %q", tt.text, tt.foldChinese, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeText(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"RTX-4090", "rtx4090"},
+		{"ＲＴＸ　４０９０！", "rtx4090"},
+		{"C# 入门", "c#入门"},
+		{"50% OFF", "50%off"},
+		{"AT&T", "at&t"},
+		{"【限时】特价，包邮。", "限时特价包邮"},
+		{"...", ""},
+	}
+	for _, tt := range tests {
+		if got := normalizeText(tt.text); got != tt.want {
+			t.Errorf("normalizeText(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
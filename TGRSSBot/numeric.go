@@ -0,0 +1,133 @@
+package main
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// 数值条件关键词
+// 格式：字段 比较符 数值，如 价格<12000、折扣>=50%、数字>100
+// 字段：价格/price 匹配金额，折扣/discount 匹配折扣力度(50% off、5折均记为50)，数字/num 匹配任意数字
+// 比较符：< <= > >= =，内容中任意一个数值满足条件即命中
+
+// numericConditionRegex 数值条件的写法
+var numericConditionRegex = regexp.MustCompile(`^(价格|售价|price|折扣|discount|数字|num)\s*(<=|>=|<|>|=)\s*(\d+(?:\.\d+)?)\s*(万|k|%)?$`)
+
+// 数值字段别名
+var numericFields = map[string]string{
+	"价格": "price", "售价": "price", "price": "price",
+	"折扣": "discount", "discount": "discount",
+	"数字": "number", "num": "number",
+}
+
+// 从内容中提取数值的正则，内容已经过折叠(半角、小写)
+var (
+	currencyPrefixRegex = regexp.MustCompile(`(?:¥|\$|€|£|rmb|cny|usd)\s*(\d[\d,]*(?:\.\d+)?)\s*(万|k)?`)
+	currencySuffixRegex = regexp.MustCompile(`(\d[\d,]*(?:\.\d+)?)\s*(万|k)?\s*(?:元|块|rmb|cny|円|美元|刀)`)
+	priceLabelRegex     = regexp.MustCompile(`(?:到手价|券后价|价格|售价|现价|特价|到手|券后)\s*:?\s*(\d[\d,]*(?:\.\d+)?)\s*(万|k)?`)
+	percentRegex        = regexp.MustCompile(`(\d+(?:\.\d+)?)\s*%`)
+	zheRegex            = regexp.MustCompile(`(\d+(?:\.\d+)?)\s*折`)
+	anyNumberRegex      = regexp.MustCompile(`(\d[\d,]*(?:\.\d+)?)\s*(万)?`)
+)
+
+// newNumericTerm 解析数值条件，不是数值条件时返回nil
+func newNumericTerm(keyword string) *keywordTerm {
+	m := numericConditionRegex.FindStringSubmatch(strings.TrimSpace(foldText(keyword)))
+	if m == nil {
+		return nil
+	}
+	value, err := parseAmount(m[3], m[4])
+	if err != nil {
+		return nil
+	}
+	return &keywordTerm{mode: "numeric", field: numericFields[m[1]], op: m[2], value: value}
+}
+
+// parseAmount 解析数字，去掉千分位并按单位换算
+func parseAmount(number, unit string) (float64, error) {
+	value, err := strconv.ParseFloat(strings.ReplaceAll(number, ",", ""), 64)
+	if err != nil {
+		return 0, err
+	}
+	switch unit {
+	case "万":
+		value *= 10000
+	case "k":
+		value *= 1000
+	}
+	return value, nil
+}
+
+// extractNumbers 按字段从内容中提取数值
+func extractNumbers(content, field string) []float64 {
+	var values []float64
+	collect := func(re *regexp.Regexp) {
+		for _, m := range re.FindAllStringSubmatch(content, -1) {
+			unit := ""
+			if len(m) > 2 {
+				unit = m[2]
+			}
+			if value, err := parseAmount(m[1], unit); err == nil {
+				values = append(values, value)
+			}
+		}
+	}
+
+	switch field {
+	case "price":
+		collect(currencyPrefixRegex)
+		collect(currencySuffixRegex)
+		collect(priceLabelRegex)
+	case "discount":
+		// 50%、-50%、50% off 均记为折扣50
+		for _, m := range percentRegex.FindAllStringSubmatch(content, -1) {
+			if value, err := strconv.ParseFloat(m[1], 64); err == nil && value > 0 && value < 100 {
+				values = append(values, value)
+			}
+		}
+		// 5折记为折扣50，85折记为折扣15
+		for _, m := range zheRegex.FindAllStringSubmatch(content, -1) {
+			value, err := strconv.ParseFloat(m[1], 64)
+			if err != nil || value <= 0 {
+				continue
+			}
+			if value < 10 {
+				values = append(values, 100-value*10)
+			} else if value < 100 {
+				values = append(values, 100-value)
+			}
+		}
+	default:
+		collect(anyNumberRegex)
+	}
+	return values
+}
+
+// matchNumeric 检查内容中是否有满足条件的数值
+func (t *keywordTerm) matchNumeric(content string) bool {
+	for _, value := range extractNumbers(content, t.field) {
+		if compareNumber(value, t.op, t.value) {
+			return true
+		}
+	}
+	return false
+}
+
+// compareNumber 按比较符比较两个数值
+func compareNumber(a float64, op string, b float64) bool {
+	switch op {
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	case ">":
+		return a > b
+	case ">=":
+		return a >= b
+	case "=":
+		return math.Abs(a-b) < 1e-9
+	}
+	return false
+}
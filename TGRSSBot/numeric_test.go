@@ -0,0 +1,138 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNewNumericTerm(t *testing.T) {
+	tests := []struct {
+		keyword string
+		field   string
+		op      string
+		value   float64
+	}{
+		{"价格<12000", "price", "<", 12000},
+		{"价格 <= 1.2万", "price", "<=", 12000},
+		{"Price>5k", "price", ">", 5000},
+		{"售价=99.5", "price", "=", 99.5},
+		{"折扣>=50%", "discount", ">=", 50},
+		{"discount > 30", "discount", ">", 30},
+		{"数字>100", "number", ">", 100},
+		{"ＮＵＭ＜１０", "number", "<", 10},
+	}
+	for _, tt := range tests {
+		term := newNumericTerm(tt.keyword)
+		if term == nil {
+			t.Errorf("newNumericTerm(%q) = nil", tt.keyword)
+			continue
+		}
+		if term.mode != "numeric" || term.field != tt.field || term.op != tt.op || term.value != tt.value {
+			t.Errorf("newNumericTerm(%q) = %s %s %v, want %s %s %v",
+				tt.keyword, term.field, term.op, term.value, tt.field, tt.op, tt.value)
+		}
+	}
+
+	for _, keyword := range []string{"价格", "价格<", "价格<abc", "4090", "价格<12000元", "重量>5"} {
+		if term := newNumericTerm(keyword); term != nil {
+			t.Errorf("newNumericTerm(%q) = %+v, want nil", keyword, term)
+		}
+	}
+}
+
+func TestParseAmount(t *testing.T) {
+	tests := []struct {
+		number string
+		unit   string
+		want   float64
+	}{
+		{"12999", "", 12999},
+		{"11,999", "", 11999},
+		{"1.2", "万", 12000},
+		{"3.5", "k", 3500},
+		{"0.99", "", 0.99},
+	}
+	for _, tt := range tests {
+		got, err := parseAmount(tt.number, tt.unit)
+		if err != nil || got != tt.want {
+			t.Errorf("parseAmount(%q, %q) = %v, %v, want %v", tt.number, tt.unit, got, err, tt.want)
+		}
+	}
+	if _, err := parseAmount("abc", ""); err == nil {
+		t.Error("parseAmount(\"abc\") succeeded, want error")
+	}
+}
+
+func TestExtractNumbers(t *testing.T) {
+	tests := []struct {
+		content string
+		field   string
+		want    []float64
+	}{
+		{"rtx 4090 ¥12,999", "price", []float64{12999}},
+		{"rtx 4090 11,999元", "price", []float64{11999}},
+		{"到手价 8999 包邮", "price", []float64{8999}},
+		{"整机 1.2万元", "price", []float64{12000}},
+		{"rtx 4090 显卡", "price", nil},
+		{"全场 50% off", "discount", []float64{50}},
+		{"限时 -30%", "discount", []float64{30}},
+		{"5折 起", "discount", []float64{50}},
+		{"85折", "discount", []float64{15}},
+		{"100% 正品", "discount", nil},
+		{"rtx 4090 24g", "number", []float64{4090, 24}},
+		{"销量 1.5万", "number", []float64{15000}},
+	}
+	for _, tt := range tests {
+		if got := extractNumbers(tt.content, tt.field); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("extractNumbers(%q, %s) = %v, want %v", tt.content, tt.field, got, tt.want)
+		}
+	}
+}
+
+func TestNumericRuleMatch(t *testing.T) {
+	tests := []struct {
+		keyword string
+		title   string
+		want    bool
+	}{
+		{"价格<12000", "RTX 4090 到手价 11999", true},
+		{"价格<12000", "RTX 4090 ¥12,999", false},
+		{"价格<=1.2万", "整机 1.2万元", true},
+		{"折扣>=50%", "全场5折", true},
+		{"折扣>=50%", "全场85折", false},
+		{"数字>100", "第 99 期", false},
+		{"4090 & 价格<12000", "RTX 4090 ￥11,999", true},
+		{"4090 & 价格<12000", "RTX 5090 ￥11,999", false},
+	}
+	for _, tt := range tests {
+		rule, err := parseKeywordRule(tt.keyword)
+		if err != nil {
+			t.Errorf("parseKeywordRule(%q) error: %v", tt.keyword, err)
+			continue
+		}
+		if got := rule.match(newMessageContent(Message{Title: tt.title})); got != tt.want {
+			t.Errorf("rule %q match %q = %v, want %v", tt.keyword, tt.title, got, tt.want)
+		}
+	}
+}
+
+func TestCompareNumber(t *testing.T) {
+	tests := []struct {
+		a    float64
+		op   string
+		b    float64
+		want bool
+	}{
+		{1, "<", 2, true},
+		{2, "<=", 2, true},
+		{3, ">", 2, true},
+		{2, ">=", 3, false},
+		{0.1 + 0.2, "=", 0.3, true},
+		{1, "!=", 2, false},
+	}
+	for _, tt := range tests {
+		if got := compareNumber(tt.a, tt.op, tt.b); got != tt.want {
+			t.Errorf("compareNumber(%v, %q, %v) = %v, want %v", tt.a, tt.op, tt.b, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// 发件箱相关常量
+const (
+	MaxOutboxAttempts = 12                 // 单条推送最大投递次数，超过后标记为失败
+	OutboxRetryBase   = time.Minute        // 投递失败后的初始重试间隔
+	OutboxRetryMax    = time.Hour          // 投递失败后的最大重试间隔
+	OutboxRetention   = 7 * 24 * time.Hour // 已完成/失败记录的保留时长
+	OutboxDrainBatch  = 200                // 每次从发件箱取出的最大条数
+
+	OutboxTargetPushinfo = "pushinfo" // 投递到配置的额外推送接口
+)
+
+// OutboxEntry 发件箱中的一条待投递推送
+type OutboxEntry struct {
+	ID             int64
+	UserID         int64
+	SubscriptionID int
+	RSSName        string
+	Text           string // 渲染好的HTML消息
+	PhotoURL       string // 图片地址，为空时发送文本消息
+	Silent         bool   // 静音推送，不发通知
+	ReplyMarkup    string // 消息下方的按钮，JSON格式，为空时不带按钮
+	Target         string // 投递目标，为空时发送给Telegram用户，pushinfo为额外推送接口
+	Attempts       int
+}
+
+// 正在发送队列中的发件箱记录，避免重复投递
+var (
+	outboxInFlight = make(map[int64]bool)
+	outboxMutex    sync.Mutex
+)
+
+// enqueuePush 将推送写入发件箱后再交给发送队列
+// 写入发件箱失败时返回错误，由调用方保留条目下次重新处理；用户处于免打扰时段时按其设置暂存或静音
+func enqueuePush(db *sql.DB, entry OutboxEntry) error {
+	return enqueueRecordedPush(db, entry, nil)
+}
+
+// enqueueRecordedPush 与enqueuePush相同，item不为nil时在同一事务中记录推送并附带收藏、屏蔽等操作按钮
+// 写入发件箱失败时推送记录一并回滚，重新处理时不会留下重复的推送记录
+func enqueueRecordedPush(db *sql.DB, entry OutboxEntry, item *pushedItem) error {
+	now := time.Now().UTC()
+	nextAttempt := now
+	if entry.Target == "" {
+		if prefs, err := getUserPrefs(db, entry.UserID); err == nil {
+			nextAttempt = prefs.applyQuietHours(&entry, now)
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("写入发件箱失败: %v", err)
+	}
+	defer tx.Rollback()
+
+	if item != nil {
+		entry.ReplyMarkup = pushReplyMarkup(tx, *item)
+	}
+	result, err := insertOutboxEntry(tx, entry, now, nextAttempt)
+	if err != nil {
+		return fmt.Errorf("写入发件箱失败: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("写入发件箱失败: %v", err)
+	}
+
+	entry.ID, _ = result.LastInsertId()
+	if nextAttempt.After(now) {
+		logMessage("debug", fmt.Sprintf("免打扰时段，推送暂存至 %s", nextAttempt.Format("2006-01-02 15:04:05")), entry.UserID)
+		return nil
+	}
+	dispatchOutboxEntry(db, entry)
+	return nil
+}
+
+// insertOutboxEntry 写入一条发件箱记录，nextAttempt之前不会投递
+func insertOutboxEntry(e execer, entry OutboxEntry, now, nextAttempt time.Time) (sql.Result, error) {
+	return e.Exec(`INSERT INTO outbox (user_id, subscription_id, rss_name, text, photo_url, silent, reply_markup, target, next_attempt, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		entry.UserID, entry.SubscriptionID, entry.RSSName, entry.Text, entry.PhotoURL, entry.Silent, entry.ReplyMarkup, entry.Target,
+		nextAttempt.UTC().Format("2006-01-02 15:04:05"), now.UTC().Format("2006-01-02 15:04:05"))
+}
+
+// dispatchOutboxEntry 将发件箱记录交给发送队列，已在队列中的记录不会重复投递
+func dispatchOutboxEntry(db *sql.DB, entry OutboxEntry) {
+	outboxMutex.Lock()
+	if outboxInFlight[entry.ID] {
+		outboxMutex.Unlock()
+		return
+	}
+	outboxInFlight[entry.ID] = true
+	outboxMutex.Unlock()
+
+	deliverOutboxEntry(db, entry)
+}
+
+// deliverOutboxEntry 发送推送，并在Telegram确认后更新发件箱状态
+func deliverOutboxEntry(db *sql.DB, entry OutboxEntry) {
+	onDone := func(err error) {
+		finishOutboxEntry(db, entry, err)
+
+		outboxMutex.Lock()
+		delete(outboxInFlight, entry.ID)
+		outboxMutex.Unlock()
+	}
+
+	if entry.Target == OutboxTargetPushinfo {
+		messageSender.QueuePushinfo(entry.Text, onDone)
+		return
+	}
+
+	options := sendOptions{Silent: entry.Silent}
+	if entry.ReplyMarkup != "" {
+		var keyboard tgbotapi.InlineKeyboardMarkup
+		if err := json.Unmarshal([]byte(entry.ReplyMarkup), &keyboard); err == nil {
+			options.Keyboard = &keyboard
+		}
+	}
+
+	if entry.PhotoURL != "" {
+		messageSender.QueuePhoto(entry.UserID, entry.PhotoURL, entry.Text, options, onDone)
+	} else {
+		messageSender.QueueHTML(entry.UserID, entry.Text, options, onDone)
+	}
+}
+
+// finishOutboxEntry 记录投递结果，失败时按指数退避安排下次投递
+func finishOutboxEntry(db *sql.DB, entry OutboxEntry, sendErr error) {
+	if sendErr == nil {
+		if _, err := db.Exec("UPDATE outbox SET status = 'done', attempts = attempts + 1 WHERE outbox_id = ?", entry.ID); err != nil {
+			logMessage("error", fmt.Sprintf("更新发件箱状态失败: %v", err), entry.UserID)
+		}
+		return
+	}
+
+	attempts := entry.Attempts + 1
+	status := "pending"
+	_, retryable := sendRetryDelay(sendErr, 1)
+	if !retryable || attempts >= MaxOutboxAttempts {
+		status = "failed"
+		logMessage("error", fmt.Sprintf("推送投递失败，已放弃(第%d次): %v", attempts, sendErr), entry.UserID)
+	}
+
+	delay := OutboxRetryBase << uint(attempts-1)
+	if delay > OutboxRetryMax || delay <= 0 {
+		delay = OutboxRetryMax
+	}
+	nextAttempt := time.Now().UTC().Add(delay).Format("2006-01-02 15:04:05")
+
+	_, err := db.Exec("UPDATE outbox SET status = ?, attempts = ?, next_attempt = ?, last_error = ? WHERE outbox_id = ?",
+		status, attempts, nextAttempt, sendErr.Error(), entry.ID)
+	if err != nil {
+		logMessage("error", fmt.Sprintf("更新发件箱状态失败: %v", err), entry.UserID)
+	}
+}
+
+// drainOutbox 投递发件箱中到期的待发送推送，启动时和每轮调度时调用
+func drainOutbox(db *sql.DB) {
+	now := time.Now().UTC().Format("2006-01-02 15:04:05")
+	rows, err := db.Query(`SELECT outbox_id, user_id, subscription_id, rss_name, text, photo_url, silent, reply_markup, target, attempts
+		FROM outbox WHERE status = 'pending' AND next_attempt <= ? ORDER BY outbox_id LIMIT ?`, now, OutboxDrainBatch)
+	if err != nil {
+		logMessage("error", fmt.Sprintf("读取发件箱失败: %v", err))
+		return
+	}
+
+	var entries []OutboxEntry
+	for rows.Next() {
+		var entry OutboxEntry
+		if err := rows.Scan(&entry.ID, &entry.UserID, &entry.SubscriptionID, &entry.RSSName,
+			&entry.Text, &entry.PhotoURL, &entry.Silent, &entry.ReplyMarkup, &entry.Target, &entry.Attempts); err != nil {
+			logMessage("error", fmt.Sprintf("读取发件箱记录失败: %v", err))
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	rows.Close()
+
+	for _, entry := range entries {
+		dispatchOutboxEntry(db, entry)
+	}
+	if len(entries) > 0 {
+		logMessage("debug", fmt.Sprintf("发件箱投递 %d 条待发送推送", len(entries)))
+	}
+}
+
+// pruneOutbox 清理过期的已完成和已失败记录
+func pruneOutbox(db *sql.DB) {
+	cutoff := time.Now().UTC().Add(-OutboxRetention).Format("2006-01-02 15:04:05")
+	if _, err := db.Exec("DELETE FROM outbox WHERE status != 'pending' AND created_at < ?", cutoff); err != nil {
+		logMessage("error", fmt.Sprintf("清理发件箱失败: %v", err))
+	}
+}
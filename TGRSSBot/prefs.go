@@ -0,0 +1,337 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 免打扰模式
+const (
+	QuietOff    = "off"    // 关闭免打扰
+	QuietHold   = "hold"   // 免打扰时段内暂存推送，结束后统一发送
+	QuietSilent = "silent" // 免打扰时段内照常推送，但不响铃不弹通知
+)
+
+// quietModeNames 免打扰模式的显示名称
+var quietModeNames = map[string]string{
+	QuietOff:    "关闭",
+	QuietHold:   "暂存，结束后统一推送",
+	QuietSilent: "静音推送",
+}
+
+// timezoneOffsetRegex 按UTC偏移设置时区的写法，如 UTC+8、+05:30、GMT-3
+var timezoneOffsetRegex = regexp.MustCompile(`^(?i:UTC|GMT)?([+-])(\d{1,2})(?::?(\d{2}))?$`)
+
+// quietHoursRegex 免打扰时段写法，如 23:00-07:00
+var quietHoursRegex = regexp.MustCompile(`^(\d{1,2}:\d{2})\s*-\s*(\d{1,2}:\d{2})$`)
+
+// quietSeparatorRegex 免打扰时段的分隔符，允许两侧有空格，兼容全角横线和波浪线
+var quietSeparatorRegex = regexp.MustCompile(`\s*[-－~～]\s*`)
+
+// defaultLocation 用户未设置时区时使用的时区，启动时按配置的 Timezone 设置
+var defaultLocation = time.FixedZone("CST", 8*60*60)
+
+// userPrefs 用户的时区和免打扰设置
+type userPrefs struct {
+	timezone   string         // 用户设置的时区，为空表示使用默认时区
+	location   *time.Location // 推送时间显示和摘要推送使用的时区
+	quietHours string         // 免打扰时段，如 23:00-07:00
+	quietMode  string         // 免打扰模式
+	quietStart int            // 免打扰开始时间(当天第几分钟)
+	quietEnd   int            // 免打扰结束时间(当天第几分钟)
+}
+
+// 用户设置缓存，只在用户修改设置时重新加载
+var (
+	prefsCache      = make(map[int64]*userPrefs)
+	prefsGeneration uint64 // 任一用户失效时递增，防止并发加载写回过期结果
+	prefsMutex      sync.RWMutex
+)
+
+// defaultUserPrefs 返回未做任何设置的用户使用的默认设置
+func defaultUserPrefs() *userPrefs {
+	return &userPrefs{location: defaultLocation, quietMode: QuietOff}
+}
+
+// parseTimezone 解析时区，支持 Asia/Shanghai 这类IANA时区名和 UTC+8 这类偏移写法
+func parseTimezone(text string) (*time.Location, error) {
+	text = strings.TrimSpace(text)
+	if m := timezoneOffsetRegex.FindStringSubmatch(text); m != nil {
+		hours, _ := strconv.Atoi(m[2])
+		minutes, _ := strconv.Atoi(m[3])
+		if hours > 14 || minutes >= 60 {
+			return nil, fmt.Errorf("时区偏移超出范围: %s", text)
+		}
+		offset := hours*3600 + minutes*60
+		if m[1] == "-" {
+			offset = -offset
+		}
+		return time.FixedZone(fmt.Sprintf("UTC%s%02d:%02d", m[1], hours, minutes), offset), nil
+	}
+	if text == "" || strings.EqualFold(text, "local") {
+		return nil, fmt.Errorf("无效的时区: %s", text)
+	}
+	location, err := time.LoadLocation(text)
+	if err != nil {
+		return nil, fmt.Errorf("无效的时区: %s", text)
+	}
+	return location, nil
+}
+
+// parseQuietHours 解析免打扰时段，返回开始和结束时间(当天第几分钟)
+func parseQuietHours(text string) (int, int, error) {
+	m := quietHoursRegex.FindStringSubmatch(strings.TrimSpace(text))
+	if m == nil {
+		return 0, 0, fmt.Errorf("免打扰时段格式错误，请使用 HH:MM-HH:MM，如 23:00-07:00")
+	}
+	var minutes [2]int
+	for i, value := range m[1:] {
+		t, err := time.Parse("15:04", value)
+		if err != nil {
+			return 0, 0, fmt.Errorf("无效的时间: %s", value)
+		}
+		minutes[i] = t.Hour()*60 + t.Minute()
+	}
+	if minutes[0] == minutes[1] {
+		return 0, 0, fmt.Errorf("免打扰开始和结束时间不能相同")
+	}
+	return minutes[0], minutes[1], nil
+}
+
+// getUserPrefs 获取用户设置，缓存中没有时从数据库加载
+func getUserPrefs(db *sql.DB, userID int64) (*userPrefs, error) {
+	prefsMutex.RLock()
+	prefs, ok := prefsCache[userID]
+	generation := prefsGeneration
+	prefsMutex.RUnlock()
+	if ok {
+		return prefs, nil
+	}
+
+	prefs = defaultUserPrefs()
+	err := db.QueryRow("SELECT timezone, quiet_hours, quiet_mode FROM users WHERE user_id = ?", userID).
+		Scan(&prefs.timezone, &prefs.quietHours, &prefs.quietMode)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	if prefs.timezone != "" {
+		if location, err := parseTimezone(prefs.timezone); err == nil {
+			prefs.location = location
+		} else {
+			logMessage("warn", fmt.Sprintf("用户时区无效，使用默认时区: %v", err), userID)
+		}
+	}
+	if prefs.quietMode != QuietOff {
+		start, end, err := parseQuietHours(prefs.quietHours)
+		if err != nil {
+			prefs.quietMode = QuietOff
+		} else {
+			prefs.quietStart, prefs.quietEnd = start, end
+		}
+	}
+
+	prefsMutex.Lock()
+	if prefsGeneration == generation {
+		prefsCache[userID] = prefs
+	}
+	prefsMutex.Unlock()
+	return prefs, nil
+}
+
+// invalidateUserPrefs 用户修改设置后清除缓存
+func invalidateUserPrefs(userID int64) {
+	prefsMutex.Lock()
+	delete(prefsCache, userID)
+	prefsGeneration++
+	prefsMutex.Unlock()
+}
+
+// userLocation 返回用户的时区，读取失败时使用默认时区
+func userLocation(userID int64) *time.Location {
+	prefs, err := loadUserPrefs(userID)
+	if err != nil {
+		return defaultLocation
+	}
+	return prefs.location
+}
+
+// quietUntil 当前处于免打扰时段时返回时段结束时间
+func (p *userPrefs) quietUntil(now time.Time) (time.Time, bool) {
+	if p.quietMode == QuietOff {
+		return time.Time{}, false
+	}
+	local := now.In(p.location)
+	minute := local.Hour()*60 + local.Minute()
+
+	var inQuiet bool
+	if p.quietStart < p.quietEnd {
+		inQuiet = minute >= p.quietStart && minute < p.quietEnd
+	} else {
+		// 跨越零点的时段，如 23:00-07:00
+		inQuiet = minute >= p.quietStart || minute < p.quietEnd
+	}
+	if !inQuiet {
+		return time.Time{}, false
+	}
+
+	end := time.Date(local.Year(), local.Month(), local.Day(), p.quietEnd/60, p.quietEnd%60, 0, 0, p.location)
+	if !end.After(local) {
+		end = end.AddDate(0, 0, 1)
+	}
+	return end, true
+}
+
+// applyQuietHours 按用户的免打扰设置处理推送，返回推送的投递时间
+// 暂存模式推迟到免打扰结束，静音模式照常投递但不发通知
+func (p *userPrefs) applyQuietHours(entry *OutboxEntry, now time.Time) time.Time {
+	end, quiet := p.quietUntil(now)
+	if !quiet {
+		return now
+	}
+	if p.quietMode == QuietSilent {
+		entry.Silent = true
+		return now
+	}
+	return end
+}
+
+// setUserTimezone 保存用户时区，为空表示恢复默认时区
+func setUserTimezone(userID int64, timezone string) error {
+	err := withDB(func(db *sql.DB) error {
+		if err := ensureUser(db, userID); err != nil {
+			return err
+		}
+		_, err := db.Exec("UPDATE users SET timezone = ? WHERE user_id = ?", timezone, userID)
+		return err
+	})
+	if err == nil {
+		invalidateUserPrefs(userID)
+	}
+	return err
+}
+
+// setUserQuietHours 保存用户的免打扰时段和模式
+func setUserQuietHours(userID int64, hours, mode string) error {
+	err := withDB(func(db *sql.DB) error {
+		if err := ensureUser(db, userID); err != nil {
+			return err
+		}
+		_, err := db.Exec("UPDATE users SET quiet_hours = ?, quiet_mode = ? WHERE user_id = ?", hours, mode, userID)
+		return err
+	})
+	if err == nil {
+		invalidateUserPrefs(userID)
+	}
+	return err
+}
+
+// loadUserPrefs 读取用户设置用于显示
+func loadUserPrefs(userID int64) (*userPrefs, error) {
+	var prefs *userPrefs
+	err := withDB(func(db *sql.DB) error {
+		var err error
+		prefs, err = getUserPrefs(db, userID)
+		return err
+	})
+	return prefs, err
+}
+
+// handleTimezoneCommand 处理 /timezone 命令，查看或设置时区
+func handleTimezoneCommand(userID int64, args string) {
+	args = strings.TrimSpace(args)
+	keyboard := CreateBackButton()
+	if args == "" {
+		prefs, err := loadUserPrefs(userID)
+		if err != nil {
+			logMessage("error", fmt.Sprintf("获取用户设置失败: %v", err), userID)
+			messageSender.SendError(userID, 0, "获取时区设置失败，请稍后重试")
+			return
+		}
+		current := prefs.timezone
+		if current == "" {
+			current = globalConfig.Timezone + "（默认）"
+		}
+		messageSender.SendResponse(userID, 0, fmt.Sprintf("🌐 当前时区：%s\n🕒 当前时间：%s\n\n"+
+			"设置方法：\n/timezone Asia/Tokyo\n/timezone UTC+8\n/timezone default  恢复默认时区",
+			current, time.Now().In(prefs.location).Format("2006-01-02 15:04")), &keyboard)
+		return
+	}
+
+	timezone := args
+	if strings.EqualFold(args, "default") {
+		timezone = ""
+	} else if _, err := parseTimezone(args); err != nil {
+		messageSender.SendError(userID, 0, fmt.Sprintf("❌ %v\n请使用 Asia/Shanghai 这类时区名或 UTC+8 这类偏移", err))
+		return
+	}
+	if err := setUserTimezone(userID, timezone); err != nil {
+		logMessage("error", fmt.Sprintf("保存时区失败: %v", err), userID)
+		messageSender.SendError(userID, 0, "保存时区失败，请稍后重试")
+		return
+	}
+
+	location := defaultLocation
+	if timezone != "" {
+		location, _ = parseTimezone(timezone)
+	}
+	messageSender.SendResponse(userID, 0, fmt.Sprintf("✅ 时区已设置为：%s\n🕒 当前时间：%s",
+		location, time.Now().In(location).Format("2006-01-02 15:04")), &keyboard)
+}
+
+// handleQuietCommand 处理 /quiet 命令，查看或设置免打扰时段
+func handleQuietCommand(userID int64, args string) {
+	// 先去掉分隔符两侧的空格，/quiet 23:00 - 07:00 与 /quiet 23:00-07:00 等价
+	fields := strings.Fields(quietSeparatorRegex.ReplaceAllString(args, "-"))
+	keyboard := CreateBackButton()
+	if len(fields) == 0 {
+		prefs, err := loadUserPrefs(userID)
+		if err != nil {
+			logMessage("error", fmt.Sprintf("获取用户设置失败: %v", err), userID)
+			messageSender.SendError(userID, 0, "获取免打扰设置失败，请稍后重试")
+			return
+		}
+		status := quietModeNames[QuietOff]
+		if prefs.quietMode != QuietOff {
+			status = fmt.Sprintf("%s（%s）", prefs.quietHours, quietModeNames[prefs.quietMode])
+		}
+		messageSender.SendResponse(userID, 0, fmt.Sprintf("🌙 免打扰：%s\n\n"+
+			"设置方法：\n/quiet 23:00-07:00  时段内暂存推送，结束后统一发送\n"+
+			"/quiet 23:00-07:00 silent  时段内静音推送\n/quiet off  关闭免打扰\n\n💡 时间按你的时区计算，可用 /timezone 设置", status), &keyboard)
+		return
+	}
+
+	hours, mode := fields[0], QuietHold
+	if strings.EqualFold(hours, QuietOff) {
+		if err := setUserQuietHours(userID, "", QuietOff); err != nil {
+			logMessage("error", fmt.Sprintf("保存免打扰设置失败: %v", err), userID)
+			messageSender.SendError(userID, 0, "保存免打扰设置失败，请稍后重试")
+			return
+		}
+		messageSender.SendResponse(userID, 0, "✅ 已关闭免打扰", &keyboard)
+		return
+	}
+	if _, _, err := parseQuietHours(hours); err != nil {
+		messageSender.SendError(userID, 0, "❌ "+err.Error())
+		return
+	}
+	if len(fields) > 1 {
+		mode = strings.ToLower(fields[1])
+		if mode != QuietHold && mode != QuietSilent {
+			messageSender.SendError(userID, 0, "❌ 免打扰模式只能是 hold(暂存) 或 silent(静音)")
+			return
+		}
+	}
+
+	if err := setUserQuietHours(userID, hours, mode); err != nil {
+		logMessage("error", fmt.Sprintf("保存免打扰设置失败: %v", err), userID)
+		messageSender.SendError(userID, 0, "保存免打扰设置失败，请稍后重试")
+		return
+	}
+	messageSender.SendResponse(userID, 0, fmt.Sprintf("✅ 免打扰已设置为 %s（%s）", hours, quietModeNames[mode]), &keyboard)
+}
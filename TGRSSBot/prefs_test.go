@@ -0,0 +1,111 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseQuietHours(t *testing.T) {
+	tests := []struct {
+		text       string
+		start, end int
+		ok         bool
+	}{
+		{"23:00-07:00", 23 * 60, 7 * 60, true},
+		{"9:30-18:00", 9*60 + 30, 18 * 60, true},
+		{" 23:00 - 07:00 ", 23 * 60, 7 * 60, true},
+		{"23:00-23:00", 0, 0, false},
+		{"25:00-07:00", 0, 0, false},
+		{"23:00", 0, 0, false},
+		{"晚上-早上", 0, 0, false},
+	}
+	for _, tt := range tests {
+		start, end, err := parseQuietHours(tt.text)
+		if (err == nil) != tt.ok || start != tt.start || end != tt.end {
+			t.Errorf("parseQuietHours(%q) = %d, %d, %v, want %d, %d, ok %v", tt.text, start, end, err, tt.start, tt.end, tt.ok)
+		}
+	}
+}
+
+func TestQuietSeparator(t *testing.T) {
+	tests := []struct {
+		args string
+		want []string
+	}{
+		{"23:00-07:00", []string{"23:00-07:00"}},
+		{"23:00 - 07:00", []string{"23:00-07:00"}},
+		{"23:00 -07:00 silent", []string{"23:00-07:00", "silent"}},
+		{"23:00 ～ 07:00", []string{"23:00-07:00"}},
+		{"23:00－07:00 hold", []string{"23:00-07:00", "hold"}},
+		{"off", []string{"off"}},
+	}
+	for _, tt := range tests {
+		got := strings.Fields(quietSeparatorRegex.ReplaceAllString(tt.args, "-"))
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("quiet args %q = %q, want %q", tt.args, got, tt.want)
+		}
+		if _, _, err := parseQuietHours(got[0]); err != nil && got[0] != "off" {
+			t.Errorf("quiet args %q: %v", tt.args, err)
+		}
+	}
+}
+
+func TestParseTimezone(t *testing.T) {
+	tests := []struct {
+		text   string
+		offset int
+		ok     bool
+	}{
+		{"UTC+8", 8 * 3600, true},
+		{"+05:30", 5*3600 + 30*60, true},
+		{"GMT-3", -3 * 3600, true},
+		{"UTC+15", 0, false},
+		{"local", 0, false},
+		{"", 0, false},
+		{"Mars/Olympus", 0, false},
+	}
+	for _, tt := range tests {
+		location, err := parseTimezone(tt.text)
+		if (err == nil) != tt.ok {
+			t.Errorf("parseTimezone(%q) error = %v, want ok %v", tt.text, err, tt.ok)
+			continue
+		}
+		if tt.ok {
+			if _, offset := time.Now().In(location).Zone(); offset != tt.offset {
+				t.Errorf("parseTimezone(%q) offset = %d, want %d", tt.text, offset, tt.offset)
+			}
+		}
+	}
+}
+
+func TestQuietUntil(t *testing.T) {
+	shanghai := time.FixedZone("UTC+08:00", 8*3600)
+	prefs := &userPrefs{location: shanghai, quietMode: QuietHold, quietStart: 23 * 60, quietEnd: 7 * 60}
+	tests := []struct {
+		now   time.Time
+		quiet bool
+		end   time.Time
+	}{
+		{time.Date(2024, 1, 1, 23, 30, 0, 0, shanghai), true, time.Date(2024, 1, 2, 7, 0, 0, 0, shanghai)},
+		{time.Date(2024, 1, 2, 6, 59, 0, 0, shanghai), true, time.Date(2024, 1, 2, 7, 0, 0, 0, shanghai)},
+		{time.Date(2024, 1, 2, 7, 0, 0, 0, shanghai), false, time.Time{}},
+		{time.Date(2024, 1, 2, 12, 0, 0, 0, shanghai), false, time.Time{}},
+		// UTC 15:30 为上海时间 23:30
+		{time.Date(2024, 1, 1, 15, 30, 0, 0, time.UTC), true, time.Date(2024, 1, 2, 7, 0, 0, 0, shanghai)},
+	}
+	for _, tt := range tests {
+		end, quiet := prefs.quietUntil(tt.now)
+		if quiet != tt.quiet || !end.Equal(tt.end) {
+			t.Errorf("quietUntil(%v) = %v, %v, want %v, %v", tt.now, end, quiet, tt.end, tt.quiet)
+		}
+	}
+
+	entry := &OutboxEntry{}
+	prefs.quietMode = QuietSilent
+	now := time.Date(2024, 1, 1, 23, 30, 0, 0, shanghai)
+	if next := prefs.applyQuietHours(entry, now); !next.Equal(now) || !entry.Silent {
+		t.Errorf("silent mode applyQuietHours = %v, silent %v, want now and silent", next, entry.Silent)
+	}
+}
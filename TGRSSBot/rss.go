@@ -1,49 +1,122 @@
 package main
 
 import (
+	"crypto/sha1"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"html"
 	"net/http"
-	"os"
+	"net/url"
 	"regexp"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/mmcdole/gofeed"
 )
 
 // 获取所有订阅
 func getSubscriptions(db *sql.DB) ([]Subscription, error) {
-	rows, err := db.Query("SELECT subscription_id, rss_url, rss_name, users, channel FROM subscriptions")
+	rows, err := db.Query("SELECT feed_id, rss_url, rss_name, channel, poll_interval FROM feeds WHERE paused = 0")
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
 	var subscriptions []Subscription
+	index := make(map[int]int)
 	for rows.Next() {
 		var sub Subscription
-		var usersStr string
 		var channel int
 
-		if err := rows.Scan(&sub.ID, &sub.URL, &sub.Name, &usersStr, &channel); err != nil {
+		if err := rows.Scan(&sub.ID, &sub.URL, &sub.Name, &channel, &sub.Interval); err != nil {
 			logMessage("error", fmt.Sprintf("读取订阅失败: %v", err))
 			continue
 		}
 
-		// 解析用户ID列表
-		sub.Users = parseUserIDs(usersStr)
 		sub.Channel = channel
+		sub.Aliases = make(map[int64]string)
+		sub.Digest = make(map[int64]bool)
+		sub.Template = make(map[int64]string)
+		sub.Muted = make(map[int64]bool)
+		index[sub.ID] = len(subscriptions)
 		subscriptions = append(subscriptions, sub)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// 读取订阅用户及其个人订阅名称
+	userRows, err := db.Query("SELECT feed_id, user_id, alias, delivery, template, muted_until FROM user_subscriptions ORDER BY created_at")
+	if err != nil {
+		return nil, err
+	}
+	defer userRows.Close()
+
+	now := time.Now().UTC().Format("2006-01-02 15:04:05")
+	for userRows.Next() {
+		var feedID int
+		var userID int64
+		var alias, delivery, template, mutedUntil string
+		if err := userRows.Scan(&feedID, &userID, &alias, &delivery, &template, &mutedUntil); err != nil {
+			logMessage("error", fmt.Sprintf("读取订阅用户失败: %v", err))
+			continue
+		}
+		i, ok := index[feedID]
+		if !ok {
+			continue // 已暂停的订阅
+		}
+		subscriptions[i].Users = append(subscriptions[i].Users, userID)
+		subscriptions[i].Aliases[userID] = alias
+		subscriptions[i].Digest[userID] = delivery == DeliveryDigest
+		subscriptions[i].Template[userID] = template
+		subscriptions[i].Muted[userID] = mutedUntil > now
+	}
 
-	return subscriptions, nil
+	return subscriptions, userRows.Err()
 }
 
-// 解析用户ID字符串
+// nameFor 返回用户为订阅设置的名称
+func (s Subscription) nameFor(userID int64) string {
+	if alias, ok := s.Aliases[userID]; ok {
+		return alias
+	}
+	return s.Name
+}
+
+// canonicalFeedURL 规范化RSS源URL，同一个源只保存一份
+// 协议和域名转小写，去掉默认端口和锚点，空路径补为"/"
+func canonicalFeedURL(rawURL string) string {
+	rawURL = strings.TrimSpace(rawURL)
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Host == "" {
+		return rawURL
+	}
+
+	parsed.Scheme = strings.ToLower(parsed.Scheme)
+	host := strings.ToLower(parsed.Hostname())
+	if port := parsed.Port(); port != "" &&
+		!(parsed.Scheme == "http" && port == "80") && !(parsed.Scheme == "https" && port == "443") {
+		host += ":" + port
+	} else if strings.Contains(host, ":") {
+		host = "[" + host + "]" // IPv6地址
+	}
+	parsed.Host = host
+	parsed.Fragment = ""
+	parsed.RawFragment = ""
+	if parsed.Path == "" {
+		parsed.Path = "/"
+	}
+	return parsed.String()
+}
+
+// parseUserIDs 解析以逗号分隔的用户ID列表，兼容旧版的JSON数组和",id,id,"格式
 func parseUserIDs(usersStr string) []int64 {
 	usersStr = strings.Trim(usersStr, "[] ")
 	if usersStr == "" {
@@ -60,31 +133,17 @@ func parseUserIDs(usersStr string) []int64 {
 	return userIDs
 }
 
-// 获取用户关键词
-func getUserKeywords(db *sql.DB) (map[int64][]string, error) {
-	rows, err := db.Query("SELECT user_id, keywords FROM user_keywords")
+// loadUserKeywords 从数据库读取单个用户的关键词
+func loadUserKeywords(db *sql.DB, userID int64) ([]string, error) {
+	var keywordsStr string
+	err := db.QueryRow("SELECT keywords FROM user_keywords WHERE user_id = ?", userID).Scan(&keywordsStr)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-
-	userKeywords := make(map[int64][]string)
-	for rows.Next() {
-		var userID int64
-		var keywordsStr string
-
-		if err := rows.Scan(&userID, &keywordsStr); err != nil {
-			continue
-		}
-
-		// 解析关键词
-		keywords := parseKeywords(keywordsStr)
-		if len(keywords) > 0 {
-			userKeywords[userID] = keywords
-		}
-	}
-
-	return userKeywords, nil
+	return parseKeywords(keywordsStr), nil
 }
 
 // 解析关键词字符串
@@ -113,55 +172,263 @@ func parseKeywords(keywordsStr string) []string {
 	return keywords
 }
 
+// fetchResult 单次抓取的结果
+type fetchResult struct {
+	Messages   []Message     // 新消息
+	FeedTTL    time.Duration // 源声明的更新间隔
+	StatusCode int           // HTTP状态码
+
+	// 推送持久化后才通过commitFetch写入的抓取状态
+	itemKeys     []string       // 本次源中所有条目的去重键
+	history      []historyEntry // 本次源中的所有条目，写入历史记录
+	etag         string         // 响应的ETag
+	lastModified string         // 响应的Last-Modified
+	latestTime   time.Time      // 最新条目时间
+	latestTitle  string         // 最新条目标题
+	modified     bool           // 是否获取到了新内容(非304)
+}
+
 // 获取RSS内容
-func fetchRSS(db *sql.DB, sub Subscription, client *http.Client) ([]Message, error) {
-	parser := gofeed.NewParser()
-	parser.Client = client
+func fetchRSS(db *sql.DB, sub Subscription, client *http.Client) (*fetchResult, error) {
+	// 读取上次的缓存校验信息，用于条件请求
+	etag, lastModified, err := getFeedCache(db, sub.ID)
+	if err != nil {
+		logMessage("error", fmt.Sprintf("获取缓存信息失败: %v", err))
+	}
 
-	// 获取RSS内容
-	feed, err := parser.ParseURL(sub.URL)
+	req, err := http.NewRequest("GET", sub.URL, nil)
 	if err != nil {
 		return nil, err
 	}
+	req.Header.Set("User-Agent", "Mozilla/5.0 (compatible; RSS Bot/1.0)")
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if lastModified != "" {
+		req.Header.Set("If-Modified-Since", lastModified)
+	}
 
-	if len(feed.Items) == 0 {
-		return nil, nil
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// 内容未变化，无需解析
+	if resp.StatusCode == http.StatusNotModified {
+		logMessage("debug", fmt.Sprintf("订阅 %s 未修改(304)", sub.Name))
+		return &fetchResult{StatusCode: resp.StatusCode}, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &fetchError{
+			StatusCode: resp.StatusCode,
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		}
+	}
+
+	// 获取RSS内容
+	parser := gofeed.NewParser()
+	parser.RSSTranslator = &feedTranslator{}
+	feed, err := parser.Parse(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	result := &fetchResult{
+		FeedTTL:      getFeedTTL(feed),
+		StatusCode:   resp.StatusCode,
+		etag:         resp.Header.Get("ETag"),
+		lastModified: resp.Header.Get("Last-Modified"),
+		modified:     true,
 	}
 
 	// 获取上次更新时间
-	lastUpdateTime, err := getLastUpdateTime(db, sub.Name)
+	lastUpdateTime, err := getLastUpdateTime(db, sub.ID)
 	if err != nil {
 		logMessage("error", fmt.Sprintf("获取更新时间失败: %v", err))
 		lastUpdateTime = time.Time{} // 使用零时间
 	}
 
+	if len(feed.Items) == 0 {
+		return result, nil
+	}
+
+	// 去重记录为空说明是首次使用去重表，此时沿用时间戳判断，避免把存量内容全部推送一遍
+	seeding, err := isSeenStoreEmpty(db, sub.ID)
+	if err != nil {
+		return nil, &storeError{fmt.Errorf("读取去重记录失败: %v", err)}
+	}
+
+	// 一次查询出本次条目中已处理过的条目
+	keys := make([]string, len(feed.Items))
+	for i, item := range feed.Items {
+		keys[i] = getItemKey(item)
+	}
+	var seen map[string]bool
+	if !seeding {
+		if seen, err = seenItemKeys(db, sub.ID, keys); err != nil {
+			return nil, &storeError{fmt.Errorf("读取去重记录失败: %v", err)}
+		}
+	}
+
 	// 处理新消息
 	var messages []Message
 	var latestTime time.Time
+	var history []historyEntry
 
-	for _, item := range feed.Items {
+	for i, item := range feed.Items {
 		pubTime := getItemTime(item)
 		if pubTime.After(latestTime) {
 			latestTime = pubTime
 		}
 
+		key := keys[i]
+		var isNew bool
+		if seeding {
+			// 没有发布时间的条目无法判断新旧，首次只记录不推送
+			hasTime := item.PublishedParsed != nil || item.UpdatedParsed != nil
+			isNew = hasTime && pubTime.After(lastUpdateTime)
+		} else {
+			isNew = !seen[key]
+		}
+
+		msg := Message{
+			Title:       item.Title,
+			Description: item.Description,
+			Link:        item.Link,
+			PubDate:     pubTime,
+			Author:      getItemAuthor(item),
+			Categories:  item.Categories,
+			Content:     item.Content,
+			Enclosures:  getItemEnclosures(item),
+		}
+		history = append(history, historyEntry{key: key, msg: msg})
+
 		// 只添加新的内容
-		if pubTime.After(lastUpdateTime) {
-			messages = append(messages, Message{
-				Title:       item.Title,
-				Description: item.Description,
-				Link:        item.Link,
-				PubDate:     pubTime,
-			})
+		if isNew {
+			messages = append(messages, msg)
 		}
 	}
 
+	result.Messages = messages
+	result.itemKeys = keys
+	result.history = history
+	result.latestTime = latestTime
+	result.latestTitle = feed.Items[0].Title
+	return result, nil
+}
+
+// commitFetch 在推送写入发件箱后保存抓取状态
+// 先持久化推送再记录已见条目，进程中途退出时条目会被重新处理，保证至少送达一次
+func commitFetch(db *sql.DB, sub Subscription, result *fetchResult) error {
+	if !result.modified {
+		return nil
+	}
+
+	// 记录已见条目，同时刷新仍在源中的条目的最后出现时间
+	if err := markItemsSeen(db, sub.ID, result.itemKeys); err != nil {
+		return fmt.Errorf("写入去重记录失败: %v", err)
+	}
+
+	// 保存条目历史，失败不影响去重和推送
+	if err := saveHistory(db, sub.ID, result.history); err != nil {
+		logMessage("error", fmt.Sprintf("保存订阅 %s 历史条目失败: %v", sub.Name, err))
+	}
+
+	// 条目处理完成后才保存缓存校验信息，避免失败时下次因304而漏掉内容
+	updateFeedCache(db, sub.ID, result.etag, result.lastModified)
+
 	// 更新最后更新时间
-	if !latestTime.IsZero() {
-		updateLastTime(db, sub.Name, latestTime, feed.Items[0].Title)
+	if !result.latestTime.IsZero() {
+		updateLastTime(db, sub.ID, result.latestTime, result.latestTitle)
+	}
+	return nil
+}
+
+// getItemKey 生成条目的去重键，优先使用GUID，其次链接，最后使用内容哈希
+func getItemKey(item *gofeed.Item) string {
+	if guid := strings.TrimSpace(item.GUID); guid != "" {
+		return "guid:" + guid
+	}
+	if link := strings.TrimSpace(item.Link); link != "" {
+		return "link:" + link
+	}
+	sum := sha1.Sum([]byte(item.Title + "\x00" + item.Description + "\x00" + item.Content))
+	return "hash:" + hex.EncodeToString(sum[:])
+}
+
+// isSeenStoreEmpty 检查订阅是否还没有任何去重记录
+func isSeenStoreEmpty(db *sql.DB, subscriptionID int) (bool, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM seen_items WHERE subscription_id = ?", subscriptionID).Scan(&count)
+	return count == 0, err
+}
+
+// seenItemKeys 批量查询已经处理过的条目，按批拼接查询参数，避免超过SQLite的参数数量限制
+func seenItemKeys(db *sql.DB, subscriptionID int, keys []string) (map[string]bool, error) {
+	seen := make(map[string]bool)
+	for start := 0; start < len(keys); start += SeenLookupBatch {
+		batch := keys[start:min(start+SeenLookupBatch, len(keys))]
+		args := []interface{}{subscriptionID}
+		for _, key := range batch {
+			args = append(args, key)
+		}
+		placeholders := strings.TrimSuffix(strings.Repeat("?,", len(batch)), ",")
+		rows, err := db.Query("SELECT item_key FROM seen_items WHERE subscription_id = ? AND item_key IN ("+placeholders+")", args...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var key string
+			if err := rows.Scan(&key); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			seen[key] = true
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
 	}
+	return seen, nil
+}
 
-	return messages, nil
+// markItemsSeen 批量写入去重记录，已存在的条目只更新最后出现时间
+func markItemsSeen(db *sql.DB, subscriptionID int, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC().Format("2006-01-02 15:04:05")
+	for _, key := range keys {
+		_, err := tx.Exec(`INSERT INTO seen_items (subscription_id, item_key, first_seen, last_seen) VALUES (?, ?, ?, ?)
+			ON CONFLICT(subscription_id, item_key) DO UPDATE SET last_seen = excluded.last_seen`,
+			subscriptionID, key, now, now)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// pruneSeenItems 清理长时间未在源中出现的去重记录
+// 仍在源中的条目每次抓取都会刷新last_seen，因此不会被误删后重复推送
+func pruneSeenItems(db *sql.DB) {
+	cutoff := time.Now().UTC().Add(-SeenItemRetention).Format("2006-01-02 15:04:05")
+	result, err := db.Exec("DELETE FROM seen_items WHERE last_seen < ?", cutoff)
+	if err != nil {
+		logMessage("error", fmt.Sprintf("清理去重记录失败: %v", err))
+		return
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		logMessage("debug", fmt.Sprintf("已清理 %d 条过期去重记录", n))
+	}
 }
 
 // 获取RSS项目的时间
@@ -175,15 +442,47 @@ func getItemTime(item *gofeed.Item) time.Time {
 	return time.Now().UTC()
 }
 
+// getItemAuthor 返回条目的作者，没有名称时使用邮箱
+func getItemAuthor(item *gofeed.Item) string {
+	authors := item.Authors
+	if len(authors) == 0 && item.Author != nil {
+		authors = []*gofeed.Person{item.Author}
+	}
+
+	var names []string
+	for _, person := range authors {
+		if person == nil {
+			continue
+		}
+		if name := strings.TrimSpace(person.Name); name != "" {
+			names = append(names, name)
+		} else if email := strings.TrimSpace(person.Email); email != "" {
+			names = append(names, email)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// getItemEnclosures 返回条目的附件地址
+func getItemEnclosures(item *gofeed.Item) []string {
+	var urls []string
+	for _, enclosure := range item.Enclosures {
+		if enclosure != nil && enclosure.URL != "" {
+			urls = append(urls, enclosure.URL)
+		}
+	}
+	return urls
+}
+
 // 获取上次更新时间
-func getLastUpdateTime(db *sql.DB, rssName string) (time.Time, error) {
+func getLastUpdateTime(db *sql.DB, feedID int) (time.Time, error) {
 	var timeStr string
-	err := db.QueryRow("SELECT last_update_time FROM feed_data WHERE rss_name = ?", rssName).Scan(&timeStr)
+	err := db.QueryRow("SELECT last_update_time FROM feed_data WHERE feed_id = ?", feedID).Scan(&timeStr)
 
 	if err == sql.ErrNoRows {
 		// 首次运行，插入记录
-		_, err = db.Exec("INSERT INTO feed_data (rss_name, last_update_time, latest_title) VALUES (?, ?, ?)",
-			rssName, time.Now().Format("2006-01-02 15:04:05"), "")
+		_, err = db.Exec("INSERT INTO feed_data (feed_id, last_update_time, latest_title) VALUES (?, ?, ?)",
+			feedID, time.Now().Format("2006-01-02 15:04:05"), "")
 		return time.Time{}, err
 	}
 
@@ -195,126 +494,113 @@ func getLastUpdateTime(db *sql.DB, rssName string) (time.Time, error) {
 }
 
 // 更新最后更新时间
-func updateLastTime(db *sql.DB, rssName string, updateTime time.Time, title string) {
-	_, err := db.Exec("UPDATE feed_data SET last_update_time = ?, latest_title = ? WHERE rss_name = ?",
-		updateTime.Format("2006-01-02 15:04:05"), title, rssName)
+func updateLastTime(db *sql.DB, feedID int, updateTime time.Time, title string) {
+	_, err := db.Exec("UPDATE feed_data SET last_update_time = ?, latest_title = ? WHERE feed_id = ?",
+		updateTime.Format("2006-01-02 15:04:05"), title, feedID)
 	if err != nil {
 		logMessage("error", fmt.Sprintf("更新时间失败: %v", err))
 	}
 }
 
-// 检查消息是否匹配关键词，返回匹配到的关键词列表
-func matchesKeywords(msg Message, keywords []string, rssName string) []string {
-	if len(keywords) == 0 {
-		return nil
+// 获取订阅的缓存校验信息
+func getFeedCache(db *sql.DB, feedID int) (string, string, error) {
+	var etag, lastModified sql.NullString
+	err := db.QueryRow("SELECT etag, last_modified FROM feed_data WHERE feed_id = ?", feedID).Scan(&etag, &lastModified)
+	if err == sql.ErrNoRows {
+		return "", "", nil
 	}
+	return etag.String, lastModified.String, err
+}
 
-	var matchedKeywords []string
-	var blockedKeywords []string
+// 更新订阅的缓存校验信息
+func updateFeedCache(db *sql.DB, feedID int, etag, lastModified string) {
+	_, err := db.Exec("UPDATE feed_data SET etag = ?, last_modified = ? WHERE feed_id = ?",
+		etag, lastModified, feedID)
+	if err != nil {
+		logMessage("error", fmt.Sprintf("更新缓存信息失败: %v", err))
+	}
+}
 
-	// 准备不同的匹配内容
-	titleContent := strings.ToLower(msg.Title)
-	descContent := strings.ToLower(msg.Description)
-	allContent := strings.ToLower(msg.Title + " " + msg.Description)
+// recordFeedSuccess 记录订阅抓取成功，清零连续失败次数
+func recordFeedSuccess(db *sql.DB, sub Subscription, statusCode int) {
+	_, err := db.Exec(`UPDATE feeds SET consecutive_failures = 0, last_error = '', last_status = ?, last_success = ?
+		WHERE feed_id = ?`, statusCode, time.Now().UTC().Format("2006-01-02 15:04:05"), sub.ID)
+	if err != nil {
+		logMessage("error", fmt.Sprintf("更新订阅状态失败: %v", err))
+	}
+}
 
-	// 首先检查是否命中屏蔽词
-	for _, keyword := range keywords {
-		keyword = strings.TrimSpace(keyword)
-		if keyword == "" {
-			continue
-		}
+// recordFeedFailure 记录订阅抓取失败，连续失败达到上限时暂停订阅并通知用户
+func recordFeedFailure(db *sql.DB, sub Subscription, fetchErr error) {
+	statusCode := 0
+	if fe, ok := fetchErr.(*fetchError); ok {
+		statusCode = fe.StatusCode
+	}
+	errText := fetchErr.Error()
+	if runes := []rune(errText); len(runes) > 200 {
+		errText = string(runes[:200]) + "..."
+	}
 
-		// 检查是否是屏蔽关键词
-		isBlockKeyword := strings.HasPrefix(keyword, "-")
-		if isBlockKeyword {
-			keyword = strings.TrimPrefix(keyword, "-")
-			//fmt.Println("屏蔽关键词:", keyword)
-		}
+	var failures int
+	err := db.QueryRow(`UPDATE feeds SET consecutive_failures = consecutive_failures + 1, last_error = ?, last_status = ?
+		WHERE feed_id = ? RETURNING consecutive_failures`, errText, statusCode, sub.ID).Scan(&failures)
+	if err != nil {
+		logMessage("error", fmt.Sprintf("更新订阅状态失败: %v", err))
+		return
+	}
 
-		// 检查匹配范围前缀 (#t=标题, #c=描述, #a=全部)
-		var matchScope string = "default" // default表示只匹配标题（保持向后兼容）
-		var processedKeyword string = keyword
-
-		if strings.HasPrefix(keyword, "#t") {
-			matchScope = "title"
-			processedKeyword = strings.TrimPrefix(keyword, "#t")
-		} else if strings.HasPrefix(keyword, "#c") {
-			matchScope = "description"
-			processedKeyword = strings.TrimPrefix(keyword, "#c")
-		} else if strings.HasPrefix(keyword, "#a") {
-			matchScope = "all"
-			processedKeyword = strings.TrimPrefix(keyword, "#a")
-		}
+	if failures < globalConfig.MaxFeedFailures {
+		return
+	}
 
-		// 移除前缀后可能存在的空格
-		processedKeyword = strings.TrimSpace(processedKeyword)
+	if _, err := db.Exec("UPDATE feeds SET paused = 1 WHERE feed_id = ?", sub.ID); err != nil {
+		logMessage("error", fmt.Sprintf("暂停订阅失败: %v", err))
+		return
+	}
+	logMessage("warn", fmt.Sprintf("订阅 %s 连续失败 %d 次，已自动暂停", sub.Name, failures))
 
-		// 检查是否包含RSS名称限制 (格式: 关键词+rssname)
-		var actualKeyword string
-		var targetRSSName string
-		hasRSSFilter := false
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🔄 重试", fmt.Sprintf("retry_sub_%d", sub.ID)),
+			tgbotapi.NewInlineKeyboardButtonData("🗑️ 删除订阅", fmt.Sprintf("del_sub_%d", sub.ID)),
+		),
+	)
+	for _, userID := range sub.Users {
+		text := fmt.Sprintf("⚠️ 订阅 <b>%s</b> 连续 %d 次获取失败，已自动暂停\n🔗 %s\n❌ %s\n\n可点击下方按钮重试或删除该订阅",
+			html.EscapeString(sub.nameFor(userID)), failures, html.EscapeString(sub.URL), html.EscapeString(errText))
+		messageSender.SendHTMLResponse(userID, 0, text, &keyboard, true)
+	}
+}
 
-		if strings.Contains(processedKeyword, "+") {
-			parts := strings.Split(processedKeyword, "+")
-			if len(parts) == 2 {
-				actualKeyword = strings.TrimSpace(parts[0])
-				targetRSSName = strings.TrimSpace(parts[1])
-				hasRSSFilter = true
-			} else {
-				actualKeyword = processedKeyword // 如果格式不正确，使用处理后的关键词
-			}
-		} else {
-			actualKeyword = processedKeyword
-		}
+// 检查消息是否匹配关键词，返回匹配到的关键词列表
+func matchesKeywords(content *messageContent, rules []*keywordRule, rssName string) []string {
+	var matchedKeywords []string
+	for _, rule := range matchRules(content, rules, rssName) {
+		matchedKeywords = append(matchedKeywords, rule.Keyword)
+	}
+	return matchedKeywords
+}
 
-		// 如果指定了RSS名称过滤，检查当前RSS是否匹配
-		if hasRSSFilter {
-			if strings.ToLower(rssName) != strings.ToLower(targetRSSName) {
-				continue // RSS名称不匹配，跳过此关键词
-			}
-		}
+// matchRules 返回命中的关键词规则，命中任何屏蔽词时返回空
+func matchRules(content *messageContent, rules []*keywordRule, rssName string) []*keywordRule {
+	if len(rules) == 0 {
+		return nil
+	}
 
-		// 将关键词转为小写
-		lowerKeyword := strings.ToLower(actualKeyword)
-
-		// 根据匹配范围选择要匹配的内容
-		var targetContent string
-		switch matchScope {
-		case "title":
-			targetContent = titleContent
-		case "description":
-			targetContent = descContent
-		case "all":
-			targetContent = allContent
-		default: // 保持向后兼容，默认只匹配标题
-			targetContent = titleContent
-		}
+	var matchedRules []*keywordRule
+	var blockedKeywords []string
 
-		// 检查是否包含通配符
-		if strings.Contains(lowerKeyword, "*") {
-			//fmt.Println(lowerKeyword)
-			// 将通配符转换为正则表达式
-			pattern := strings.ReplaceAll(lowerKeyword, "*", ".*")
-			pattern = "^.*" + pattern + ".*$"
-
-			// 编译正则表达式
-			re, err := regexp.Compile(pattern)
-			if err == nil && re.MatchString(targetContent) {
-				if isBlockKeyword {
-					blockedKeywords = append(blockedKeywords, actualKeyword)
-				} else {
-					matchedKeywords = append(matchedKeywords, actualKeyword)
-				}
-				continue
-			}
+	for _, rule := range rules {
+		// 如果指定了RSS名称过滤，检查当前RSS是否匹配
+		if !rule.appliesTo(rssName) {
+			continue // RSS名称不匹配，跳过此关键词
 		}
 
-		// 如果没有通配符或正则表达式失败，使用普通匹配
-		if strings.Contains(targetContent, lowerKeyword) {
-			if isBlockKeyword {
-				blockedKeywords = append(blockedKeywords, actualKeyword)
+		if rule.match(content) {
+			if rule.Block {
+				blockedKeywords = append(blockedKeywords, rule.Keyword)
 			} else {
-				matchedKeywords = append(matchedKeywords, actualKeyword)
+				matchedRules = append(matchedRules, rule)
 			}
 		}
 	}
@@ -322,38 +608,92 @@ func matchesKeywords(msg Message, keywords []string, rssName string) []string {
 	// 如果命中任何屏蔽词，则返回空
 	if len(blockedKeywords) > 0 {
 		logMessage("debug", fmt.Sprintf("消息被屏蔽词[%s]过滤: %s",
-			strings.Join(blockedKeywords, ", "), msg.Title))
+			strings.Join(blockedKeywords, ", "), content.msg.Title))
 		return nil
 	}
 
-	return matchedKeywords
+	return matchedRules
 }
 
 // 处理单个订阅
-func processSubscription(db *sql.DB, sub Subscription, userKeywords map[int64][]string, client *http.Client) {
+func processSubscription(db *sql.DB, sub Subscription, client *http.Client) {
 	if cyclenum == 0 {
 		logMessage("info", fmt.Sprintf("处理订阅: %s (%s)", sub.Name, sub.URL))
 	}
-	messages, err := fetchRSS(db, sub, client)
+	result, err := fetchRSS(db, sub, client)
+	var localErr *storeError
+	if errors.As(err, &localErr) {
+		// 本地数据库暂时不可用，按基础间隔重试，不影响订阅的健康状态和退避
+		scheduler.retry(sub)
+		logMessage("error", fmt.Sprintf("处理订阅 %s 失败: %v", sub.Name, err))
+		return
+	}
 	if err != nil {
+		scheduler.record(sub, false, 0, err)
 		logMessage("error", fmt.Sprintf("获取RSS失败 %s: %v", sub.Name, err))
+		recordFeedFailure(db, sub, err)
 		return
 	}
+	scheduler.record(sub, len(result.Messages) > 0, result.FeedTTL, nil)
+	recordFeedSuccess(db, sub, result.StatusCode)
+	messages := result.Messages
 
 	if len(messages) == 0 {
 		logMessage("debug", fmt.Sprintf("订阅 %s 无新内容", sub.Name))
+		if err := commitFetch(db, sub, result); err != nil {
+			logMessage("error", fmt.Sprintf("保存订阅 %s 抓取状态失败: %v", sub.Name, err))
+		}
 		return
 	}
 
+	// 按发布时间从旧到新推送，保证同一会话内按发布顺序送达
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].PubDate.Before(messages[j].PubDate)
+	})
+
+	// 获取订阅用户编译好的关键词
+	matchers := make(map[int64]*userMatcher, len(sub.Users))
+	for _, userID := range sub.Users {
+		matcher, err := getUserMatcher(db, userID)
+		if err != nil {
+			logMessage("error", fmt.Sprintf("获取用户关键词失败: %v", err), userID)
+			continue
+		}
+		matchers[userID] = matcher
+	}
+	// 获取订阅用户的时区设置，用于显示发布时间
+	prefs := make(map[int64]*userPrefs, len(sub.Users))
+	for _, userID := range sub.Users {
+		p, err := getUserPrefs(db, userID)
+		if err != nil {
+			logMessage("error", fmt.Sprintf("获取用户设置失败: %v", err), userID)
+			p = defaultUserPrefs()
+		}
+		prefs[userID] = p
+	}
+
 	// 处理推送
 	pushCount := 0
+	persistFailed := false // 有推送未能持久化时不记录已见条目，下次重新处理
 	for _, msg := range messages {
+		content := newMessageContent(msg)
 		for _, userID := range sub.Users {
-			keywords := userKeywords[userID]
-			if len(keywords) == 0 {
+			matcher := matchers[userID]
+			if matcher == nil || sub.Muted[userID] {
+				continue
+			}
+			// 按用户对该订阅的过滤设置选择匹配规则
+			rules := matcher.rulesFor(sub.ID)
+			if len(rules) == 0 {
 				continue
 			}
-			matchedKeywords := matchesKeywords(msg, keywords, sub.Name)
+			// 关键词中的 +RSS名称 按用户自己设置的订阅名称匹配
+			name := sub.nameFor(userID)
+			matched := matchRules(content, rules, name)
+			matchedKeywords := make([]string, len(matched))
+			for i, rule := range matched {
+				matchedKeywords[i] = rule.Keyword
+			}
 
 			// 如果匹配到关键词或是全量推送，则发送消息
 			if len(matchedKeywords) > 0 {
@@ -361,65 +701,83 @@ func processSubscription(db *sql.DB, sub Subscription, userKeywords map[int64][]
 				//if len(matchedKeywords) > 0 {
 				logMessage("debug", fmt.Sprintf("关键词[%s]匹配 推送给用户 %d: %s",
 					strings.Join(matchedKeywords, ", "), userID, msg.Title))
-				// 这里添加实际的推送逻辑
-				recordPush(sub.Name)
-				// 格式化关键词列表，每个关键词单独用code标签包裹
-				var formattedKeywords string
-				if len(matchedKeywords) > 0 {
-					keywordCodes := make([]string, len(matchedKeywords))
-					for i, kw := range matchedKeywords {
-						keywordCodes[i] = fmt.Sprintf("<code>%s</code>", kw)
+				// 摘要推送的订阅先保存，到用户设置的时间再汇总推送，推送统计在摘要发出时计入
+				if sub.Digest[userID] {
+					if err := addDigestItem(db, userID, sub.ID, msg, matchedKeywords); err != nil {
+						logMessage("error", fmt.Sprintf("保存摘要条目失败: %v", err), userID)
+						persistFailed = true
 					}
-					formattedKeywords = strings.Join(keywordCodes, " ")
+					continue
 				}
-				title := msg.Title
-				description := msg.Description
-				link := msg.Link
-
-				// 提取图片URL并清理HTML内容
-
-				// 格式化时间
-				formattedDate := msg.PubDate.In(time.FixedZone("CST", 8*60*60)).Format("2006-01-02 15:04:05")
-				// 构造HTML消息
-				var htmlMessage, otherpush string
+				recordPush(sub.Name)
+				// 按用户设置的模板和时区渲染推送内容
+				location := prefs[userID].location
+				data := newPushTemplateData(msg, name, matchedKeywords, location, true)
+				entry := OutboxEntry{
+					UserID:         userID,
+					SubscriptionID: sub.ID,
+					RSSName:        name,
+					Text:           renderPush(sub.Template[userID], sub.Channel, data),
+				}
+				// TG频道订阅有图片时以图片消息发送
 				if sub.Channel == 1 {
-					imageURL := extractImageURL(description)
-					cleanDescription := cleanHTMLContent(description)
-					htmlMessage = fmt.Sprintf("👋 %s: %s\n🕒 %s\n%s\n", sub.Name, formattedKeywords, formattedDate, cleanDescription)
-					otherpush = fmt.Sprintf("👋 %s\n🕒 %s\n%s", sub.Name, formattedDate, cleanDescription)
-					// 根据是否有图片决定发送方式
-					if imageURL != "" {
-						// 如果找到图片，发送图片消息
-						go sendPhotoMessage(userID, imageURL, htmlMessage)
-					} else {
-						// 如果没有图片，发送普通HTML消息
-						go sendHTMLMessage(userID, htmlMessage)
-					}
-				} else {
-					htmlMessage = fmt.Sprintf("📌 %s\n🔖 关键词: %s\n🕒 %s\n🔗 %s", title, formattedKeywords, formattedDate, link)
-					otherpush = fmt.Sprintf("📌 %s\n🕒 %s\n🔗 %s", title, formattedDate, link)
-					go sendHTMLMessage(userID, htmlMessage)
+					entry.PhotoURL = data.imageURL
 				}
-				if userID == globalConfig.ADMINIDS {
-					go sendother(otherpush)
+				// 记录推送，消息下方附带收藏、屏蔽等操作按钮；推送记录与发件箱在同一事务中写入
+				item := pushedItem{
+					UserID:      userID,
+					FeedID:      sub.ID,
+					FeedName:    name,
+					Title:       msg.Title,
+					Link:        msg.Link,
+					Description: data.Description,
+					Keywords:    ruleRaws(matched),
+					PublishedAt: msg.PubDate.UTC().Format("2006-01-02 15:04:05"),
+				}
+				// 先写入发件箱再投递
+				if err := enqueueRecordedPush(db, entry, &item); err != nil {
+					logMessage("error", fmt.Sprintf("推送持久化失败: %v", err), userID)
+					persistFailed = true
+				}
+
+				// 额外推送同样经过发件箱和发送队列
+				if userID == globalConfig.ADMINIDS && globalConfig.Pushinfo != "" {
+					raw := newPushTemplateData(msg, name, matchedKeywords, location, false)
+					otherpush, err := renderPushTemplate(pushinfoTemplateFor(sub.Channel), raw)
+					if err != nil {
+						logMessage("error", fmt.Sprintf("额外推送模板渲染失败: %v", err))
+					} else if err := enqueuePush(db, OutboxEntry{UserID: userID, SubscriptionID: sub.ID, RSSName: name,
+						Text: otherpush, Target: OutboxTargetPushinfo}); err != nil {
+						logMessage("error", fmt.Sprintf("额外推送持久化失败: %v", err), userID)
+						persistFailed = true
+					}
 				}
 			}
 		}
 	}
+
+	// 推送已全部写入发件箱，再记录已见条目
+	if persistFailed {
+		// 不保存去重记录和缓存校验信息，下一轮重新抓取并处理这些条目
+		scheduler.reset(sub.ID)
+		logMessage("error", fmt.Sprintf("订阅 %s 有推送未能写入发件箱，下一轮重新处理", sub.Name))
+		return
+	}
+	if err := commitFetch(db, sub, result); err != nil {
+		logMessage("error", fmt.Sprintf("保存订阅 %s 抓取状态失败: %v", sub.Name, err))
+	}
 	logMessage("info", fmt.Sprintf("订阅 %s 完成，推送 %d 条消息", sub.Name, pushCount))
 }
 
 // 检查所有RSS订阅
-func checkAllRSS(db *sql.DB) {
-	db, err := sql.Open("sqlite3", "tgbot.db")
-	if err != nil {
-		logMessage("error", fmt.Sprintf("连接数据库失败: %v", err))
-		os.Exit(1)
-	}
-	defer db.Close()
+func checkAllRSS(db *sql.DB, client *http.Client) {
 	startTime := time.Now()
 	resetPushStatsIfNeeded()
-	logMessage("info", "开始检查RSS订阅...")
+	logMessage("debug", "开始检查RSS订阅...")
+	pruneSeenItems(db)
+	pruneHistory(db)
+	pruneOutbox(db)
+	prunePushedItems(db)
 
 	// 获取数据
 	subscriptions, err := getSubscriptions(db)
@@ -433,26 +791,42 @@ func checkAllRSS(db *sql.DB) {
 		return
 	}
 
-	userKeywords, err := getUserKeywords(db)
-	if err != nil {
-		logMessage("error", fmt.Sprintf("获取用户关键词失败: %v", err))
+	// 只处理已到检查时间的订阅
+	subscriptions = scheduler.dueSubscriptions(subscriptions, time.Now())
+	if len(subscriptions) == 0 {
+		logMessage("debug", "没有到期需要检查的订阅")
 		return
 	}
 
-	client := createHTTPClient(globalConfig.ProxyURL)
+	// 使用固定数量的工作协程处理订阅，同一站点的并发数单独限制
+	workers := globalConfig.FetchConcurrency
+	if workers > len(subscriptions) {
+		workers = len(subscriptions)
+	}
+	limiter := newHostLimiter(globalConfig.PerHostConcurrency)
+	jobs := make(chan Subscription)
 
-	// 并发处理订阅
 	var wg sync.WaitGroup
-	for _, sub := range subscriptions {
+	for i := 0; i < workers; i++ {
 		wg.Add(1)
-		go func(sub Subscription) {
+		go func() {
 			defer wg.Done()
-			processSubscription(db, sub, userKeywords, client)
-		}(sub)
+			for sub := range jobs {
+				host := subscriptionHost(sub)
+				limiter.acquire(host)
+				safeProcessSubscription(db, sub, client)
+				limiter.release(host)
+			}
+		}()
 	}
 
+	for _, sub := range interleaveByHost(subscriptions) {
+		jobs <- sub
+	}
+	close(jobs)
+
 	wg.Wait()
-	logMessage("info", fmt.Sprintf("RSS检查完成，耗时: %v", time.Since(startTime)))
+	logMessage("info", fmt.Sprintf("RSS检查完成，共 %d 个订阅，耗时: %v", len(subscriptions), time.Since(startTime)))
 	cyclenum = 1
 	// 打印当前的推送统计
 	//stats := GetPushStatsInfo()
@@ -461,6 +835,46 @@ func checkAllRSS(db *sql.DB) {
 	//}
 }
 
+// safeProcessSubscription 处理单个订阅，避免单个订阅的panic影响整个工作协程
+func safeProcessSubscription(db *sql.DB, sub Subscription, client *http.Client) {
+	defer func() {
+		if r := recover(); r != nil {
+			logMessage("error", fmt.Sprintf("处理订阅 %s 时发生panic: %v", sub.Name, r))
+		}
+	}()
+	processSubscription(db, sub, client)
+}
+
+// richerBody 返回更完整的正文，content:encoded的文字比描述多时使用正文
+func richerBody(msg Message) string {
+	if msg.Content == "" {
+		return msg.Description
+	}
+	if len([]rune(plainText(msg.Content))) > len([]rune(plainText(msg.Description))) {
+		return msg.Content
+	}
+	return msg.Description
+}
+
+// plainText 去掉HTML标签并还原实体，得到纯文本
+func plainText(htmlContent string) string {
+	return strings.TrimSpace(html.UnescapeString(htmlTagRegex.ReplaceAllString(htmlContent, "")))
+}
+
+var htmlTagRegex = regexp.MustCompile(`<[^>]*>`)
+
+// truncateHTMLBody 截断过长的正文，截断时转为纯文本避免留下不完整的标签
+func truncateHTMLBody(content string, limit int) string {
+	if len([]rune(content)) <= limit {
+		return content
+	}
+	runes := []rune(plainText(content))
+	if len(runes) > limit {
+		runes = append(runes[:limit], []rune("…")...)
+	}
+	return html.EscapeString(string(runes))
+}
+
 // extractImageURL 从HTML内容中提取第一个图片URL
 func extractImageURL(htmlContent string) string {
 	// 1. 正则表达式匹配img标签的src属性
@@ -0,0 +1,422 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// 收藏相关常量
+const (
+	SavedPageSize       = 5                                    // 收藏列表每页条数
+	SavedTitleMaxLength = 200                                  // 转发收藏时标题的最大字数
+	SavedFeedLink       = "https://github.com/IonRh/TGBot_RSS" // 导出RSS的频道链接
+)
+
+// savedItem 用户收藏的条目
+type savedItem struct {
+	ID          int64
+	FeedName    string
+	Title       string
+	Link        string
+	Description string
+	PublishedAt time.Time
+	SavedAt     time.Time
+}
+
+// savedRSS 导出收藏使用的RSS 2.0结构
+type savedRSS struct {
+	XMLName xml.Name        `xml:"rss"`
+	Version string          `xml:"version,attr"`
+	Channel savedRSSChannel `xml:"channel"`
+}
+
+type savedRSSChannel struct {
+	Title       string         `xml:"title"`
+	Link        string         `xml:"link"`
+	Description string         `xml:"description"`
+	Items       []savedRSSItem `xml:"item"`
+}
+
+type savedRSSItem struct {
+	Title       string `xml:"title"`
+	Link        string `xml:"link,omitempty"`
+	Description string `xml:"description,omitempty"`
+	Category    string `xml:"category,omitempty"`
+	GUID        string `xml:"guid"`
+	PubDate     string `xml:"pubDate"`
+}
+
+// addSavedItem 添加收藏，链接相同的条目只保存一次，返回是否新增
+func addSavedItem(db *sql.DB, userID int64, item savedItem) (bool, error) {
+	if item.Link != "" {
+		var exists bool
+		err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM saved_items WHERE user_id = ? AND link = ?)", userID, item.Link).Scan(&exists)
+		if err != nil || exists {
+			return false, err
+		}
+	}
+	if err := ensureUser(db, userID); err != nil {
+		return false, err
+	}
+	_, err := db.Exec(`INSERT INTO saved_items (user_id, feed_name, title, link, description, published_at, saved_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`, userID, item.FeedName, item.Title, item.Link, item.Description,
+		item.PublishedAt.UTC().Format("2006-01-02 15:04:05"), time.Now().UTC().Format("2006-01-02 15:04:05"))
+	return err == nil, err
+}
+
+// listSavedItems 分页获取用户的收藏，page从0开始，pageSize为0时返回全部
+func listSavedItems(db *sql.DB, userID int64, page, pageSize int) ([]savedItem, int, error) {
+	var total int
+	if err := db.QueryRow("SELECT COUNT(*) FROM saved_items WHERE user_id = ?", userID).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := `SELECT saved_item_id, feed_name, title, link, description, published_at, saved_at
+		FROM saved_items WHERE user_id = ? ORDER BY saved_at DESC, saved_item_id DESC`
+	args := []interface{}{userID}
+	if pageSize > 0 {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, pageSize, page*pageSize)
+	}
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var items []savedItem
+	for rows.Next() {
+		var item savedItem
+		var published, saved string
+		if err := rows.Scan(&item.ID, &item.FeedName, &item.Title, &item.Link, &item.Description, &published, &saved); err != nil {
+			return nil, 0, err
+		}
+		item.PublishedAt, _ = time.Parse("2006-01-02 15:04:05", published)
+		item.SavedAt, _ = time.Parse("2006-01-02 15:04:05", saved)
+		items = append(items, item)
+	}
+	return items, total, rows.Err()
+}
+
+// deleteSavedItem 删除用户的一条收藏
+func deleteSavedItem(db *sql.DB, userID int64, itemID int64) (bool, error) {
+	result, err := db.Exec("DELETE FROM saved_items WHERE saved_item_id = ? AND user_id = ?", itemID, userID)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// formatSavedItem 格式化收藏列表中的一条
+func formatSavedItem(index int, item savedItem, location *time.Location) string {
+	title := html.EscapeString(item.Title)
+	if item.Link != "" {
+		title = fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(item.Link), title)
+	}
+	line := fmt.Sprintf("%d. %s\n", index, title)
+	if item.FeedName != "" {
+		line += fmt.Sprintf("📰 %s  ", html.EscapeString(item.FeedName))
+	}
+	return line + "⭐ " + item.SavedAt.In(location).Format("2006-01-02 15:04")
+}
+
+// showSavedPage 显示收藏列表的一页
+func showSavedPage(userID int64, messageID int, page int) {
+	var items []savedItem
+	var total int
+	err := withDB(func(db *sql.DB) error {
+		var err error
+		items, total, err = listSavedItems(db, userID, page, SavedPageSize)
+		if err == nil && len(items) == 0 && page > 0 {
+			// 删除最后一页的最后一条后回到上一页
+			page = (total - 1) / SavedPageSize
+			items, total, err = listSavedItems(db, userID, page, SavedPageSize)
+		}
+		return err
+	})
+	if err != nil {
+		logMessage("error", fmt.Sprintf("获取收藏失败: %v", err), userID)
+		messageSender.SendError(userID, messageID, "获取收藏失败，请稍后重试")
+		return
+	}
+	if total == 0 {
+		keyboard := CreateBackButton()
+		messageSender.SendResponse(userID, messageID, "⭐ 你还没有收藏任何内容\n\n点击推送下方的 \"⭐ 收藏\"，或把推送消息转发给我即可收藏", &keyboard)
+		return
+	}
+
+	pages := (total + SavedPageSize - 1) / SavedPageSize
+	lines := []string{fmt.Sprintf("⭐ 我的收藏 共 %d 条，第 %d/%d 页", total, page+1, pages)}
+	location := userLocation(userID)
+	var deleteRow []tgbotapi.InlineKeyboardButton
+	for i, item := range items {
+		index := page*SavedPageSize + i + 1
+		lines = append(lines, formatSavedItem(index, item, location))
+		deleteRow = append(deleteRow, tgbotapi.NewInlineKeyboardButtonData(
+			fmt.Sprintf("🗑️ %d", index), fmt.Sprintf("saved_del_%d_%d", item.ID, page)))
+	}
+
+	rows := [][]tgbotapi.InlineKeyboardButton{deleteRow}
+	var nav []tgbotapi.InlineKeyboardButton
+	if page > 0 {
+		nav = append(nav, tgbotapi.NewInlineKeyboardButtonData("⬅️ 上一页", "saved_"+strconv.Itoa(page-1)))
+	}
+	if page+1 < pages {
+		nav = append(nav, tgbotapi.NewInlineKeyboardButtonData("➡️ 下一页", "saved_"+strconv.Itoa(page+1)))
+	}
+	if len(nav) > 0 {
+		rows = append(rows, nav)
+	}
+	rows = append(rows,
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("📝 导出Markdown", "saved_md"),
+			tgbotapi.NewInlineKeyboardButtonData("📡 导出RSS", "saved_rss"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🔙 返回主菜单", "back_to_menu"),
+		),
+	)
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	messageSender.SendHTMLResponse(userID, messageID, strings.Join(lines, "\n\n"), &keyboard, true)
+}
+
+// removeSavedItem 处理删除收藏按钮，删除后刷新当前页
+func removeSavedItem(userID int64, messageID int, itemID int64, page int) {
+	err := withDB(func(db *sql.DB) error {
+		_, err := deleteSavedItem(db, userID, itemID)
+		return err
+	})
+	if err != nil {
+		logMessage("error", fmt.Sprintf("删除收藏失败: %v", err), userID)
+		messageSender.SendError(userID, messageID, "删除收藏失败，请稍后重试")
+		return
+	}
+	showSavedPage(userID, messageID, page)
+}
+
+// getAllSavedItems 获取用户的全部收藏，用于导出
+func getAllSavedItems(userID int64) ([]savedItem, error) {
+	var items []savedItem
+	err := withDB(func(db *sql.DB) error {
+		var err error
+		items, _, err = listSavedItems(db, userID, 0, 0)
+		return err
+	})
+	return items, err
+}
+
+// markdownEscaper 转义Markdown链接文字中的特殊字符
+var markdownEscaper = strings.NewReplacer(`\`, `\\`, `[`, `\[`, `]`, `\]`)
+
+// formatSavedMarkdown 将收藏格式化为Markdown
+func formatSavedMarkdown(items []savedItem, location *time.Location) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# 我的收藏（%d 条）\n", len(items))
+	for _, item := range items {
+		title := markdownEscaper.Replace(item.Title)
+		if item.Link != "" {
+			fmt.Fprintf(&b, "\n- [%s](<%s>)", title, item.Link)
+		} else {
+			fmt.Fprintf(&b, "\n- %s", title)
+		}
+		if item.FeedName != "" {
+			fmt.Fprintf(&b, " — %s", item.FeedName)
+		}
+		fmt.Fprintf(&b, " · %s", item.PublishedAt.In(location).Format("2006-01-02 15:04"))
+		if item.Description != "" {
+			fmt.Fprintf(&b, "\n  > %s", strings.Join(strings.Fields(item.Description), " "))
+		}
+	}
+	return b.String()
+}
+
+// exportSavedMarkdown 以Markdown文本发送全部收藏，过长时分段发送
+func exportSavedMarkdown(userID int64) {
+	items, err := getAllSavedItems(userID)
+	if err != nil {
+		logMessage("error", fmt.Sprintf("导出收藏失败: %v", err), userID)
+		messageSender.SendError(userID, 0, "导出收藏失败，请稍后重试")
+		return
+	}
+	if len(items) == 0 {
+		messageSender.SendError(userID, 0, "你还没有收藏任何内容")
+		return
+	}
+	messageSender.HandleLongText(userID, 0, formatSavedMarkdown(items, userLocation(userID)), true)
+}
+
+// buildSavedRSS 将收藏生成为RSS 2.0文档
+func buildSavedRSS(items []savedItem) ([]byte, error) {
+	feed := savedRSS{
+		Version: "2.0",
+		Channel: savedRSSChannel{
+			Title:       "TGBot_RSS 我的收藏",
+			Link:        SavedFeedLink,
+			Description: "通过 TGBot_RSS 收藏的内容",
+		},
+	}
+	for _, item := range items {
+		feed.Channel.Items = append(feed.Channel.Items, savedRSSItem{
+			Title:       item.Title,
+			Link:        item.Link,
+			Description: item.Description,
+			Category:    item.FeedName,
+			GUID:        fmt.Sprintf("tgbot-rss-saved-%d", item.ID),
+			PubDate:     item.PublishedAt.UTC().Format(time.RFC1123Z),
+		})
+	}
+	data, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), data...), nil
+}
+
+// exportSavedRSS 以RSS文件发送全部收藏
+func exportSavedRSS(userID int64) {
+	items, err := getAllSavedItems(userID)
+	if err != nil {
+		logMessage("error", fmt.Sprintf("导出收藏失败: %v", err), userID)
+		messageSender.SendError(userID, 0, "导出收藏失败，请稍后重试")
+		return
+	}
+	if len(items) == 0 {
+		messageSender.SendError(userID, 0, "你还没有收藏任何内容")
+		return
+	}
+	data, err := buildSavedRSS(items)
+	if err != nil {
+		logMessage("error", fmt.Sprintf("生成收藏RSS失败: %v", err), userID)
+		messageSender.SendError(userID, 0, "导出收藏失败，请稍后重试")
+		return
+	}
+
+	doc := tgbotapi.NewDocument(userID, tgbotapi.FileBytes{Name: "saved.xml", Bytes: data})
+	doc.Caption = fmt.Sprintf("📡 你的收藏 RSS，共 %d 条", len(items))
+	if _, err := messageSender.queue.Send(userID, doc); err != nil {
+		logMessage("error", fmt.Sprintf("发送收藏RSS失败: %v", err), userID)
+	}
+}
+
+// handleSavedCommand 处理 /saved 命令
+func handleSavedCommand(userID int64, args string) {
+	switch strings.ToLower(strings.TrimSpace(args)) {
+	case "":
+		showSavedPage(userID, 0, 0)
+	case "md", "markdown":
+		exportSavedMarkdown(userID)
+	case "rss":
+		exportSavedRSS(userID)
+	default:
+		messageSender.SendError(userID, 0, "用法：\n/saved 查看收藏\n/saved md 导出为Markdown\n/saved rss 导出为RSS文件")
+	}
+}
+
+// isForwardedPush 判断消息是否为用户转发回来的Bot推送
+func isForwardedPush(message *tgbotapi.Message) bool {
+	return message.ForwardFrom != nil && bot != nil && message.ForwardFrom.ID == bot.Self.ID
+}
+
+// messageLinks 提取消息中的链接，实体偏移量按UTF-16计算
+func messageLinks(text string, entities []tgbotapi.MessageEntity) []string {
+	units := utf16.Encode([]rune(text))
+	var links []string
+	for _, entity := range entities {
+		switch entity.Type {
+		case "text_link":
+			links = append(links, entity.URL)
+		case "url":
+			if entity.Offset >= 0 && entity.Offset+entity.Length <= len(units) {
+				links = append(links, string(utf16.Decode(units[entity.Offset:entity.Offset+entity.Length])))
+			}
+		}
+	}
+	return links
+}
+
+// findPushedItemByLink 按链接查找用户最近的推送记录
+func findPushedItemByLink(db *sql.DB, userID int64, links []string) (*pushedItem, error) {
+	for _, link := range links {
+		var itemID int64
+		err := db.QueryRow("SELECT pushed_item_id FROM pushed_items WHERE user_id = ? AND link = ? ORDER BY pushed_item_id DESC LIMIT 1",
+			userID, link).Scan(&itemID)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return getPushedItem(db, userID, itemID)
+	}
+	return nil, sql.ErrNoRows
+}
+
+// saveForwardedMessage 收藏用户转发回来的推送
+// 能找到推送记录时按记录保存，否则以消息首行作为标题、第一个链接作为原文链接
+func saveForwardedMessage(message *tgbotapi.Message) {
+	userID := message.From.ID
+	text, entities := message.Text, message.Entities
+	if text == "" {
+		text, entities = message.Caption, message.CaptionEntities
+	}
+	links := messageLinks(text, entities)
+
+	var item savedItem
+	var added bool
+	err := withDB(func(db *sql.DB) error {
+		pushed, err := findPushedItemByLink(db, userID, links)
+		switch {
+		case err == nil:
+			item = savedItem{FeedName: pushed.FeedName, Title: pushed.Title, Link: pushed.Link, Description: pushed.Description}
+			item.PublishedAt, _ = time.Parse("2006-01-02 15:04:05", pushed.PublishedAt)
+		case err == sql.ErrNoRows:
+			item = savedItem{PublishedAt: time.Unix(int64(message.ForwardDate), 0)}
+			for _, line := range strings.Split(text, "\n") {
+				if line = strings.TrimSpace(line); line != "" {
+					item.Title = line
+					break
+				}
+			}
+			if title := []rune(item.Title); len(title) > SavedTitleMaxLength {
+				item.Title = string(title[:SavedTitleMaxLength]) + "…"
+			}
+			if len(links) > 0 {
+				item.Link = links[0]
+			}
+			if item.Title == "" && item.Link == "" {
+				return nil
+			}
+			if item.Title == "" {
+				item.Title = item.Link
+			}
+		default:
+			return err
+		}
+		added, err = addSavedItem(db, userID, item)
+		return err
+	})
+	if err != nil {
+		logMessage("error", fmt.Sprintf("收藏转发消息失败: %v", err), userID)
+		messageSender.SendError(userID, 0, "收藏失败，请稍后重试")
+		return
+	}
+	if item.Title == "" {
+		messageSender.SendError(userID, 0, "无法识别转发的消息，只能收藏带有文字或链接的推送")
+		return
+	}
+
+	text = fmt.Sprintf("⭐ 已收藏：%s\n\n使用 /saved 查看收藏", item.Title)
+	if !added {
+		text = fmt.Sprintf("已经收藏过了：%s\n\n使用 /saved 查看收藏", item.Title)
+	}
+	sendMessage(userID, text)
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/mmcdole/gofeed/rss"
+)
+
+// 调度相关常量
+const (
+	SchedulerTick        = time.Minute      // 调度器检查到期订阅的间隔
+	MaxPollInterval      = 12 * time.Hour   // 退避后的最大检查间隔
+	UnchangedBackoffStep = 6                // 连续多少次无新内容后放慢一级
+	MaxUnchangedFactor   = 4                // 无新内容时最多放慢的倍数
+	MinRetryAfter        = 30 * time.Second // Retry-After的最小等待时间
+)
+
+// feedSchedule 单个订阅的调度状态
+type feedSchedule struct {
+	nextCheck  time.Time     // 下次检查时间
+	failures   int           // 连续失败次数
+	unchanged  int           // 连续无新内容次数
+	feedMinTTL time.Duration // 源声明的更新间隔(ttl/sy:updatePeriod)
+}
+
+// feedScheduler 按订阅维护下次检查时间
+type feedScheduler struct {
+	mutex sync.Mutex
+	feeds map[int]*feedSchedule
+}
+
+// 全局调度器
+var scheduler = &feedScheduler{feeds: make(map[int]*feedSchedule)}
+
+// fetchError 抓取失败时携带的HTTP信息
+type fetchError struct {
+	StatusCode int           // HTTP状态码
+	RetryAfter time.Duration // 服务端要求的等待时间
+}
+
+func (e *fetchError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("HTTP状态码错误: %d (Retry-After %v)", e.StatusCode, e.RetryAfter)
+	}
+	return fmt.Sprintf("HTTP状态码错误: %d", e.StatusCode)
+}
+
+// MaxIntervalMinutes 添加订阅时可指定的最大检查间隔(分钟)
+const MaxIntervalMinutes = int(MaxPollInterval / time.Minute)
+
+// storeError 抓取过程中读取本地数据库失败，与源无关，不计入订阅的连续失败次数
+type storeError struct {
+	err error
+}
+
+func (e *storeError) Error() string {
+	return e.err.Error()
+}
+
+func (e *storeError) Unwrap() error {
+	return e.err
+}
+
+// baseInterval 返回订阅的基础检查间隔，限制在调度周期和最大检查间隔之间
+func baseInterval(sub Subscription) time.Duration {
+	minutes := globalConfig.Cycletime
+	if sub.Interval > 0 {
+		minutes = sub.Interval
+	}
+	if minutes <= 0 {
+		return SchedulerTick
+	}
+	if minutes >= MaxIntervalMinutes {
+		return MaxPollInterval
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// backoffInterval 连续失败时按指数退避，翻倍到最大检查间隔后不再增加，避免移位溢出
+func backoffInterval(interval time.Duration, failures int) time.Duration {
+	for i := 0; i < failures && interval < MaxPollInterval; i++ {
+		interval *= 2
+	}
+	if interval > MaxPollInterval {
+		interval = MaxPollInterval
+	}
+	return interval
+}
+
+// dueSubscriptions 过滤出已到检查时间的订阅，并清理已删除订阅的调度状态
+func (s *feedScheduler) dueSubscriptions(subscriptions []Subscription, now time.Time) []Subscription {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	active := make(map[int]bool, len(subscriptions))
+	var due []Subscription
+	for _, sub := range subscriptions {
+		active[sub.ID] = true
+		state, ok := s.feeds[sub.ID]
+		if !ok || !now.Before(state.nextCheck) {
+			due = append(due, sub)
+		}
+	}
+
+	for id := range s.feeds {
+		if !active[id] {
+			delete(s.feeds, id)
+		}
+	}
+	return due
+}
+
+// record 根据本次抓取结果计算下次检查时间
+func (s *feedScheduler) record(sub Subscription, hasNew bool, feedTTL time.Duration, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	state, ok := s.feeds[sub.ID]
+	if !ok {
+		state = &feedSchedule{}
+		s.feeds[sub.ID] = state
+	}
+	if feedTTL > 0 {
+		state.feedMinTTL = feedTTL
+	}
+
+	interval := baseInterval(sub)
+	var retryAfter time.Duration
+
+	if err != nil {
+		state.failures++
+		// 连续失败按指数退避
+		interval = backoffInterval(interval, state.failures)
+		if fe, ok := err.(*fetchError); ok {
+			retryAfter = fe.RetryAfter
+		}
+	} else {
+		state.failures = 0
+		if hasNew {
+			state.unchanged = 0
+		} else {
+			state.unchanged++
+		}
+		// 长时间无新内容的源逐步放慢检查频率
+		factor := 1 << uint(state.unchanged/UnchangedBackoffStep)
+		if factor > MaxUnchangedFactor {
+			factor = MaxUnchangedFactor
+		}
+		interval *= time.Duration(factor)
+		// 源声明的更新间隔作为下限
+		if state.feedMinTTL > interval {
+			interval = state.feedMinTTL
+		}
+	}
+
+	if interval > MaxPollInterval {
+		interval = MaxPollInterval
+	}
+	if retryAfter > interval {
+		interval = retryAfter
+	}
+
+	state.nextCheck = time.Now().Add(interval)
+	logMessage("debug", fmt.Sprintf("订阅 %s 下次检查: %s", sub.Name, state.nextCheck.Format("2006-01-02 15:04:05")))
+}
+
+// retry 本地出错时按基础间隔安排下次检查，不改变失败次数、无更新计数和源声明的ttl
+func (s *feedScheduler) retry(sub Subscription) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	state, ok := s.feeds[sub.ID]
+	if !ok {
+		state = &feedSchedule{}
+		s.feeds[sub.ID] = state
+	}
+	state.nextCheck = time.Now().Add(baseInterval(sub))
+	logMessage("debug", fmt.Sprintf("订阅 %s 本地出错，下次检查: %s", sub.Name, state.nextCheck.Format("2006-01-02 15:04:05")))
+}
+
+// reset 清除订阅的调度状态，使其在下一轮立即检查
+func (s *feedScheduler) reset(subscriptionID int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.feeds, subscriptionID)
+}
+
+// feedTranslator 在默认转换的基础上保留RSS的<ttl>字段
+type feedTranslator struct {
+	gofeed.DefaultRSSTranslator
+}
+
+func (t *feedTranslator) Translate(feed interface{}) (*gofeed.Feed, error) {
+	result, err := t.DefaultRSSTranslator.Translate(feed)
+	if err != nil {
+		return nil, err
+	}
+	if rssFeed, ok := feed.(*rss.Feed); ok && strings.TrimSpace(rssFeed.TTL) != "" {
+		if result.Custom == nil {
+			result.Custom = make(map[string]string)
+		}
+		result.Custom["ttl"] = strings.TrimSpace(rssFeed.TTL)
+	}
+	return result, nil
+}
+
+// getFeedTTL 读取源声明的更新间隔，支持<ttl>和sy:updatePeriod/sy:updateFrequency
+func getFeedTTL(feed *gofeed.Feed) time.Duration {
+	if minutes, err := strconv.Atoi(feed.Custom["ttl"]); err == nil && minutes > 0 {
+		return time.Duration(minutes) * time.Minute
+	}
+
+	sy, ok := feed.Extensions["sy"]
+	if !ok {
+		return 0
+	}
+
+	var period time.Duration
+	if values := sy["updatePeriod"]; len(values) > 0 {
+		switch strings.ToLower(strings.TrimSpace(values[0].Value)) {
+		case "hourly":
+			period = time.Hour
+		case "daily":
+			period = 24 * time.Hour
+		case "weekly":
+			period = 7 * 24 * time.Hour
+		case "monthly":
+			period = 30 * 24 * time.Hour
+		case "yearly":
+			period = 365 * 24 * time.Hour
+		}
+	}
+	if period == 0 {
+		return 0
+	}
+
+	frequency := 1
+	if values := sy["updateFrequency"]; len(values) > 0 {
+		if n, err := strconv.Atoi(strings.TrimSpace(values[0].Value)); err == nil && n > 0 {
+			frequency = n
+		}
+	}
+	return period / time.Duration(frequency)
+}
+
+// parseRetryAfter 解析Retry-After响应头，支持秒数和HTTP日期两种格式
+func parseRetryAfter(value string) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+
+	var wait time.Duration
+	if seconds, err := strconv.Atoi(value); err == nil {
+		wait = time.Duration(seconds) * time.Second
+	} else if t, err := http.ParseTime(value); err == nil {
+		wait = time.Until(t)
+	}
+
+	if wait <= 0 {
+		return 0
+	}
+	if wait < MinRetryAfter {
+		wait = MinRetryAfter
+	}
+	return wait
+}
+
+// hostLimiter 限制同一站点的并发请求数
+type hostLimiter struct {
+	mutex sync.Mutex
+	limit int
+	hosts map[string]chan struct{}
+}
+
+func newHostLimiter(limit int) *hostLimiter {
+	return &hostLimiter{limit: limit, hosts: make(map[string]chan struct{})}
+}
+
+// acquire 获取站点的并发名额，名额用完时阻塞等待
+func (l *hostLimiter) acquire(host string) {
+	l.mutex.Lock()
+	sem, ok := l.hosts[host]
+	if !ok {
+		sem = make(chan struct{}, l.limit)
+		l.hosts[host] = sem
+	}
+	l.mutex.Unlock()
+	sem <- struct{}{}
+}
+
+// release 归还站点的并发名额
+func (l *hostLimiter) release(host string) {
+	l.mutex.Lock()
+	sem := l.hosts[host]
+	l.mutex.Unlock()
+	<-sem
+}
+
+// subscriptionHost 返回订阅所在的站点
+func subscriptionHost(sub Subscription) string {
+	if parsed, err := url.Parse(sub.URL); err == nil && parsed.Host != "" {
+		return strings.ToLower(parsed.Host)
+	}
+	return sub.URL
+}
+
+// interleaveByHost 按站点轮流排列订阅，减少工作协程在同一站点上排队等待
+func interleaveByHost(subscriptions []Subscription) []Subscription {
+	var hosts []string
+	groups := make(map[string][]Subscription)
+	for _, sub := range subscriptions {
+		host := subscriptionHost(sub)
+		if _, ok := groups[host]; !ok {
+			hosts = append(hosts, host)
+		}
+		groups[host] = append(groups[host], sub)
+	}
+
+	result := make([]Subscription, 0, len(subscriptions))
+	for len(result) < len(subscriptions) {
+		for _, host := range hosts {
+			if group := groups[host]; len(group) > 0 {
+				result = append(result, group[0])
+				groups[host] = group[1:]
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBaseInterval(t *testing.T) {
+	globalConfig.Cycletime = 5
+	defer func() { globalConfig.Cycletime = 1 }()
+
+	tests := []struct {
+		name     string
+		interval int
+		want     time.Duration
+	}{
+		{"默认周期", 0, 5 * time.Minute},
+		{"指定间隔", 30, 30 * time.Minute},
+		{"最大间隔", MaxIntervalMinutes, MaxPollInterval},
+		{"超过最大间隔", 1 << 40, MaxPollInterval},
+	}
+	for _, tt := range tests {
+		if got := baseInterval(Subscription{Interval: tt.interval}); got != tt.want {
+			t.Errorf("%s: baseInterval(%d) = %v, want %v", tt.name, tt.interval, got, tt.want)
+		}
+	}
+
+	globalConfig.Cycletime = 0
+	if got := baseInterval(Subscription{}); got != SchedulerTick {
+		t.Errorf("Cycletime为0时 baseInterval = %v, want %v", got, SchedulerTick)
+	}
+}
+
+func TestBackoffInterval(t *testing.T) {
+	tests := []struct {
+		interval time.Duration
+		failures int
+		want     time.Duration
+	}{
+		{time.Minute, 0, time.Minute},
+		{time.Minute, 1, 2 * time.Minute},
+		{time.Minute, 3, 8 * time.Minute},
+		{time.Minute, 20, MaxPollInterval},
+		{time.Minute, 1000, MaxPollInterval},
+		{10 * time.Hour, 1, MaxPollInterval},
+		{MaxPollInterval, 64, MaxPollInterval},
+	}
+	for _, tt := range tests {
+		if got := backoffInterval(tt.interval, tt.failures); got != tt.want {
+			t.Errorf("backoffInterval(%v, %d) = %v, want %v", tt.interval, tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestSchedulerRecord(t *testing.T) {
+	s := &feedScheduler{feeds: make(map[int]*feedSchedule)}
+	sub := Subscription{ID: 1, Name: "test", Interval: 10}
+
+	// 连续失败多次后检查间隔不超过最大值，也不会溢出为负数
+	for i := 0; i < 100; i++ {
+		s.record(sub, false, 0, errors.New("失败"))
+	}
+	wait := time.Until(s.feeds[1].nextCheck)
+	if wait <= 0 || wait > MaxPollInterval {
+		t.Fatalf("连续失败后的等待时间 = %v", wait)
+	}
+
+	// Retry-After 大于退避间隔时按 Retry-After 等待
+	s.record(sub, true, 0, nil)
+	s.record(sub, false, 0, &fetchError{StatusCode: 429, RetryAfter: 2 * time.Hour})
+	if wait := time.Until(s.feeds[1].nextCheck); wait < 2*time.Hour-time.Minute {
+		t.Fatalf("Retry-After 未生效，等待时间 = %v", wait)
+	}
+
+	// 成功后恢复基础间隔，源声明的ttl作为下限
+	s.record(sub, true, time.Hour, nil)
+	if wait := time.Until(s.feeds[1].nextCheck); wait < 59*time.Minute || wait > time.Hour {
+		t.Fatalf("ttl下限未生效，等待时间 = %v", wait)
+	}
+}
+
+func TestSchedulerRetry(t *testing.T) {
+	s := &feedScheduler{feeds: make(map[int]*feedSchedule)}
+	sub := Subscription{ID: 1, Name: "test", Interval: 10}
+
+	// 本地出错不清除失败次数，也不累计无更新次数
+	s.record(sub, false, 0, errors.New("失败"))
+	s.record(sub, false, 0, errors.New("失败"))
+	s.feeds[1].unchanged = 7
+	s.feeds[1].feedMinTTL = time.Hour
+	s.retry(sub)
+
+	state := s.feeds[1]
+	if state.failures != 2 || state.unchanged != 7 || state.feedMinTTL != time.Hour {
+		t.Fatalf("retry 修改了调度状态: failures=%d unchanged=%d ttl=%v", state.failures, state.unchanged, state.feedMinTTL)
+	}
+	if wait := time.Until(state.nextCheck); wait <= 9*time.Minute || wait > 10*time.Minute {
+		t.Fatalf("retry 后的等待时间 = %v，应为基础间隔", wait)
+	}
+
+	// 之后再次失败仍按累计的失败次数退避
+	s.record(sub, false, 0, errors.New("失败"))
+	if s.feeds[1].failures != 3 {
+		t.Fatalf("failures = %d, want 3", s.feeds[1].failures)
+	}
+
+	// 没有调度记录的订阅也能重试
+	s.retry(Subscription{ID: 2, Name: "new", Interval: 5})
+	if state, ok := s.feeds[2]; !ok || state.failures != 0 {
+		t.Fatalf("新订阅的调度状态 = %+v", state)
+	}
+}
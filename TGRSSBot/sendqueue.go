@@ -0,0 +1,204 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// 发送限速相关常量
+const (
+	GlobalSendRate   = 25.0             // 全局每秒发送条数，略低于Telegram的30条/秒限制
+	ChatSendRate     = 1.0              // 单个会话每秒发送条数
+	MaxSendAttempts  = 5                // 单条消息最大发送次数
+	SendRetryBase    = time.Second      // 发送失败重试的初始等待时间
+	ChatQueueIdleTTL = 10 * time.Minute // 空闲会话队列的保留时长
+	PushinfoQueueKey = 0                // 额外推送接口使用的队列，Telegram会话ID不会为0
+)
+
+// tokenBucket 令牌桶限速器
+type tokenBucket struct {
+	mutex  sync.Mutex
+	rate   float64 // 每秒补充的令牌数
+	burst  float64 // 令牌桶容量
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate, burst float64) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// wait 阻塞直到获取到一个令牌
+func (b *tokenBucket) wait() {
+	for {
+		b.mutex.Lock()
+		now := time.Now()
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+
+		if b.tokens >= 1 {
+			b.tokens--
+			b.mutex.Unlock()
+			return
+		}
+		delay := time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+		b.mutex.Unlock()
+		time.Sleep(delay)
+	}
+}
+
+// outgoingMessage 待发送的消息
+type outgoingMessage struct {
+	msg      tgbotapi.Chattable // 要发送的消息
+	fallback tgbotapi.Chattable // 发送失败时的替代消息，可为nil
+	job      func() error       // 不经过Telegram发送的任务，不为nil时忽略msg
+	onDone   func(error)        // 发送完成后的回调，可为nil
+}
+
+// chatQueue 单个会话的发送队列，保证同一会话内按入队顺序发送
+type chatQueue struct {
+	items    []outgoingMessage
+	running  bool
+	bucket   *tokenBucket
+	lastUsed time.Time
+}
+
+// SendQueue 统一的发送队列，同时遵守全局和单会话的发送频率限制
+type SendQueue struct {
+	bot    *tgbotapi.BotAPI
+	global *tokenBucket
+	mutex  sync.Mutex
+	chats  map[int64]*chatQueue
+}
+
+func NewSendQueue(bot *tgbotapi.BotAPI) *SendQueue {
+	return &SendQueue{
+		bot:    bot,
+		global: newTokenBucket(GlobalSendRate, GlobalSendRate),
+		chats:  make(map[int64]*chatQueue),
+	}
+}
+
+// chat 获取会话队列，调用方需持有锁
+func (q *SendQueue) chat(chatID int64) *chatQueue {
+	cq, ok := q.chats[chatID]
+	if !ok {
+		cq = &chatQueue{bucket: newTokenBucket(ChatSendRate, 1)}
+		q.chats[chatID] = cq
+	}
+	cq.lastUsed = time.Now()
+	return cq
+}
+
+// Enqueue 将消息加入会话队列，异步按顺序发送
+func (q *SendQueue) Enqueue(chatID int64, item outgoingMessage) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	cq := q.chat(chatID)
+	cq.items = append(cq.items, item)
+	if !cq.running {
+		cq.running = true
+		go q.drain(chatID, cq)
+	}
+	q.pruneIdle()
+}
+
+// drain 依次发送会话队列中的消息，队列清空后退出
+func (q *SendQueue) drain(chatID int64, cq *chatQueue) {
+	for {
+		q.mutex.Lock()
+		if len(cq.items) == 0 {
+			cq.running = false
+			q.mutex.Unlock()
+			return
+		}
+		item := cq.items[0]
+		cq.items = cq.items[1:]
+		q.mutex.Unlock()
+
+		if item.job != nil {
+			cq.bucket.wait()
+			err := item.job()
+			if item.onDone != nil {
+				item.onDone(err)
+			}
+			continue
+		}
+
+		err := q.send(cq.bucket, item.msg)
+		if err != nil && item.fallback != nil {
+			logMessage("warn", fmt.Sprintf("消息发送失败，改用替代消息: %v", err), chatID)
+			err = q.send(cq.bucket, item.fallback)
+		}
+		if err != nil {
+			logMessage("error", fmt.Sprintf("推送消息失败: %v", err), chatID)
+		}
+		if item.onDone != nil {
+			item.onDone(err)
+		}
+	}
+}
+
+// Send 同步发送一条消息，同样遵守频率限制，用于需要立即得到结果的交互消息
+func (q *SendQueue) Send(chatID int64, msg tgbotapi.Chattable) (tgbotapi.Message, error) {
+	q.mutex.Lock()
+	bucket := q.chat(chatID).bucket
+	q.mutex.Unlock()
+
+	q.global.wait()
+	bucket.wait()
+	return q.bot.Send(msg)
+}
+
+// send 发送消息，遇到限流按retry_after等待，遇到临时错误按指数退避重试
+func (q *SendQueue) send(bucket *tokenBucket, msg tgbotapi.Chattable) error {
+	var err error
+	for attempt := 1; attempt <= MaxSendAttempts; attempt++ {
+		bucket.wait()
+		q.global.wait()
+
+		if _, err = q.bot.Send(msg); err == nil {
+			return nil
+		}
+
+		wait, retryable := sendRetryDelay(err, attempt)
+		if !retryable || attempt == MaxSendAttempts {
+			break
+		}
+		logMessage("debug", fmt.Sprintf("发送失败，%v 后重试(%d/%d): %v", wait, attempt, MaxSendAttempts, err))
+		time.Sleep(wait)
+	}
+	return err
+}
+
+// sendRetryDelay 判断发送错误是否可重试，并返回重试前的等待时间
+func sendRetryDelay(err error, attempt int) (time.Duration, bool) {
+	var apiErr *tgbotapi.Error
+	if errors.As(err, &apiErr) {
+		if apiErr.RetryAfter > 0 {
+			return time.Duration(apiErr.RetryAfter) * time.Second, true
+		}
+		// 4xx错误(如用户屏蔽Bot、消息格式错误)重试无意义
+		if apiErr.Code >= 400 && apiErr.Code < 500 {
+			return 0, false
+		}
+	}
+	return SendRetryBase << uint(attempt-1), true
+}
+
+// pruneIdle 清理长时间空闲的会话队列，调用方需持有锁
+func (q *SendQueue) pruneIdle() {
+	for chatID, cq := range q.chats {
+		if !cq.running && len(cq.items) == 0 && time.Since(cq.lastUsed) > ChatQueueIdleTTL {
+			delete(q.chats, chatID)
+		}
+	}
+}
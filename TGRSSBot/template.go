@@ -0,0 +1,443 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"html"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// 推送模板相关常量
+const (
+	DefaultPushTemplate            = "📌 {{.Title}}\n🔖 关键词: {{.Keywords}}\n🕒 {{.Time}}\n🔗 {{.Link}}" // 常规订阅默认模板
+	DefaultChannelTemplate         = "👋 {{.Feed}}: {{.Keywords}}\n🕒 {{.Time}}\n{{.Content}}\n"      // TG频道订阅默认模板
+	DefaultPushinfoTemplate        = "📌 {{.Title}}\n🕒 {{.Time}}\n🔗 {{.Link}}"                       // 常规订阅额外推送默认模板
+	DefaultChannelPushinfoTemplate = "👋 {{.Feed}}\n🕒 {{.Time}}\n{{.Content}}"                       // TG频道订阅额外推送默认模板
+
+	MaxTemplateLength    = 1000 // 模板最大长度
+	DescriptionSnippet   = 200  // 描述摘要最大字数
+	MaxTemplateCacheSize = 200  // 模板缓存的最大条数，超出后清空重建
+)
+
+// templateHelp 模板占位符说明
+const templateHelp = `可用占位符：
+{{.Title}} 标题
+{{.Link}} 原文链接
+{{.Feed}} 订阅名称
+{{.Keywords}} 命中的关键词
+{{.Author}} 作者
+{{.Time}} 发布时间
+{{.Description}} 描述摘要(纯文本)
+{{.Content}} 正文(保留加粗、链接等格式)
+{{.Image}} 图片地址
+支持 text/template 语法，如 {{if .Author}}✍️ {{.Author}}{{end}}`
+
+// pushTemplateData 渲染推送模板使用的数据
+type pushTemplateData struct {
+	Title       string   // 标题
+	Link        string   // 原文链接
+	Feed        string   // 订阅名称
+	Keywords    string   // 命中的关键词，每个关键词用code标签包裹
+	KeywordList []string // 命中的关键词列表
+	Author      string   // 作者
+	Time        string   // 发布时间，按用户时区格式化
+	Description string   // 描述摘要，纯文本
+	Content     string   // 清理后的正文HTML，过长时截断
+	Image       string   // 正文中的第一张图片
+
+	imageURL string // 未转义的图片地址，用于发送图片消息
+}
+
+// 已解析的模板缓存，同一模板只解析一次
+var (
+	templateCache = make(map[string]*template.Template)
+	templateMutex sync.RWMutex
+)
+
+// newPushTemplateData 构造模板数据，escape为true时按Telegram HTML转义文本字段
+func newPushTemplateData(msg Message, feed string, keywords []string, location *time.Location, escape bool) pushTemplateData {
+	text := func(s string) string {
+		if escape {
+			return html.EscapeString(s)
+		}
+		return s
+	}
+
+	body := richerBody(msg)
+	image := extractImageURL(body)
+	if image == "" && body != msg.Description {
+		image = extractImageURL(msg.Description)
+	}
+	snippet := []rune(plainText(msg.Description))
+	if len(snippet) > DescriptionSnippet {
+		snippet = append(snippet[:DescriptionSnippet], []rune("…")...)
+	}
+
+	data := pushTemplateData{
+		Title:       text(msg.Title),
+		Link:        text(msg.Link),
+		Feed:        text(feed),
+		Author:      text(msg.Author),
+		Time:        msg.PubDate.In(location).Format("2006-01-02 15:04:05"),
+		Description: text(string(snippet)),
+		Content:     truncateHTMLBody(cleanHTMLContent(body), MaxPushBodyLength),
+		Image:       text(image),
+		imageURL:    image,
+	}
+	codes := make([]string, len(keywords))
+	for i, keyword := range keywords {
+		data.KeywordList = append(data.KeywordList, text(keyword))
+		if escape {
+			codes[i] = fmt.Sprintf("<code>%s</code>", html.EscapeString(keyword))
+		} else {
+			codes[i] = keyword
+		}
+	}
+	data.Keywords = strings.Join(codes, " ")
+	return data
+}
+
+// parsePushTemplate 解析模板，结果会被缓存
+func parsePushTemplate(text string) (*template.Template, error) {
+	templateMutex.RLock()
+	tmpl, ok := templateCache[text]
+	templateMutex.RUnlock()
+	if ok {
+		return tmpl, nil
+	}
+
+	tmpl, err := template.New("push").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	templateMutex.Lock()
+	if len(templateCache) >= MaxTemplateCacheSize {
+		templateCache = make(map[string]*template.Template)
+	}
+	templateCache[text] = tmpl
+	templateMutex.Unlock()
+	return tmpl, nil
+}
+
+// renderPushTemplate 按模板渲染推送内容
+func renderPushTemplate(text string, data pushTemplateData) (string, error) {
+	tmpl, err := parsePushTemplate(text)
+	if err != nil {
+		return "", err
+	}
+	var out strings.Builder
+	if err := tmpl.Execute(&out, data); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// defaultTemplateFor 返回管理员配置的默认模板，未配置时使用内置模板
+func defaultTemplateFor(channel int) string {
+	if channel == 1 {
+		if globalConfig != nil && globalConfig.ChannelTemplate != "" {
+			return globalConfig.ChannelTemplate
+		}
+		return DefaultChannelTemplate
+	}
+	if globalConfig != nil && globalConfig.PushTemplate != "" {
+		return globalConfig.PushTemplate
+	}
+	return DefaultPushTemplate
+}
+
+// pushinfoTemplateFor 返回额外推送使用的模板
+func pushinfoTemplateFor(channel int) string {
+	if globalConfig != nil && globalConfig.PushinfoTemplate != "" {
+		return globalConfig.PushinfoTemplate
+	}
+	if channel == 1 {
+		return DefaultChannelPushinfoTemplate
+	}
+	return DefaultPushinfoTemplate
+}
+
+// renderPush 按用户模板渲染推送，模板出错时使用默认模板
+func renderPush(userTemplate string, channel int, data pushTemplateData) string {
+	if userTemplate != "" {
+		text, err := renderPushTemplate(userTemplate, data)
+		if err == nil {
+			return text
+		}
+		logMessage("warn", fmt.Sprintf("推送模板渲染失败，使用默认模板: %v", err))
+	}
+	text, err := renderPushTemplate(defaultTemplateFor(channel), data)
+	if err != nil {
+		logMessage("error", fmt.Sprintf("默认推送模板渲染失败: %v", err))
+		text, _ = renderPushTemplate(DefaultPushTemplate, data)
+	}
+	return text
+}
+
+// telegramTags Telegram HTML支持的标签及允许的属性
+var telegramTags = map[string][]string{
+	"b": nil, "strong": nil, "i": nil, "em": nil, "u": nil, "ins": nil,
+	"s": nil, "strike": nil, "del": nil, "tg-spoiler": nil,
+	"span":       {"class"},
+	"a":          {"href"},
+	"code":       {"class"},
+	"pre":        nil,
+	"blockquote": {"expandable"},
+	"tg-emoji":   {"emoji-id"},
+}
+
+var (
+	htmlEntityRegex = regexp.MustCompile(`^&(lt|gt|amp|quot|#[0-9]+|#x[0-9a-fA-F]+);`)
+	htmlTagPattern  = regexp.MustCompile(`^<(/?)([a-zA-Z][a-zA-Z0-9-]*)((?:\s+[a-zA-Z-]+(?:\s*=\s*(?:"[^"]*"|'[^']*'|[^\s"'>]+))?)*)\s*>`)
+	htmlAttrPattern = regexp.MustCompile(`([a-zA-Z-]+)(?:\s*=\s*(?:"[^"]*"|'[^']*'|[^\s"'>]+))?`)
+)
+
+// validateTelegramHTML 检查内容是否为Telegram可以解析的HTML
+// 只允许Telegram支持的标签和属性，标签必须正确嵌套闭合，&只能用于实体
+func validateTelegramHTML(text string) error {
+	var stack []string
+	for i := 0; i < len(text); {
+		switch text[i] {
+		case '&':
+			entity := htmlEntityRegex.FindString(text[i:])
+			if entity == "" {
+				return fmt.Errorf("第 %d 个字符处的 & 需要写成 &amp;", len([]rune(text[:i]))+1)
+			}
+			i += len(entity)
+		case '<':
+			m := htmlTagPattern.FindStringSubmatch(text[i:])
+			if m == nil {
+				return fmt.Errorf("第 %d 个字符处的 < 不是有效的标签，普通文字请写成 &lt;", len([]rune(text[:i]))+1)
+			}
+			name := strings.ToLower(m[2])
+			allowed, ok := telegramTags[name]
+			if !ok {
+				return fmt.Errorf("Telegram不支持 <%s> 标签", name)
+			}
+			if m[1] == "/" {
+				if len(stack) == 0 || stack[len(stack)-1] != name {
+					return fmt.Errorf("</%s> 没有对应的开始标签", name)
+				}
+				stack = stack[:len(stack)-1]
+			} else {
+				for _, attr := range htmlAttrPattern.FindAllStringSubmatch(m[3], -1) {
+					if !slices.Contains(allowed, strings.ToLower(attr[1])) {
+						return fmt.Errorf("<%s> 标签不支持 %s 属性", name, attr[1])
+					}
+				}
+				stack = append(stack, name)
+			}
+			i += len(m[0])
+		default:
+			i++
+		}
+	}
+	if len(stack) > 0 {
+		return fmt.Errorf("<%s> 标签没有闭合", stack[len(stack)-1])
+	}
+	return nil
+}
+
+// sampleMessage 没有历史条目时用于预览和校验模板的示例条目
+func sampleMessage() Message {
+	return Message{
+		Title:       "示例标题 <RTX 4090> & 显卡",
+		Description: "示例描述",
+		Content:     `<p>这是一段<b>示例正文</b>，用于预览推送模板的效果，<a href="https://example.com/">示例链接</a></p><img src="https://example.com/image.jpg">`,
+		Link:        "https://example.com/post?id=1&from=rss",
+		PubDate:     time.Now(),
+		Author:      "示例作者",
+	}
+}
+
+// checkPushTemplate 用示例内容渲染模板并校验输出，返回渲染结果
+func checkPushTemplate(text string, data pushTemplateData) (string, error) {
+	if len([]rune(text)) > MaxTemplateLength {
+		return "", fmt.Errorf("模板不能超过 %d 个字符", MaxTemplateLength)
+	}
+	rendered, err := renderPushTemplate(text, data)
+	if err != nil {
+		return "", fmt.Errorf("模板语法错误: %v", err)
+	}
+	if strings.TrimSpace(rendered) == "" {
+		return "", fmt.Errorf("模板渲染结果为空")
+	}
+	if len(rendered) > MaxMessageLength {
+		return "", fmt.Errorf("渲染结果超过 Telegram 消息长度限制")
+	}
+	if err := validateTelegramHTML(rendered); err != nil {
+		return "", fmt.Errorf("渲染结果不是有效的 Telegram HTML: %v", err)
+	}
+	return rendered, nil
+}
+
+// validateConfigTemplates 校验管理员在配置文件中设置的模板
+func validateConfigTemplates(config *Config) error {
+	data := newPushTemplateData(sampleMessage(), "示例订阅", []string{"示例"}, time.UTC, true)
+	for name, text := range map[string]string{"PushTemplate": config.PushTemplate, "ChannelTemplate": config.ChannelTemplate} {
+		if text == "" {
+			continue
+		}
+		if _, err := checkPushTemplate(text, data); err != nil {
+			return fmt.Errorf("%s配置错误: %v", name, err)
+		}
+	}
+	if config.PushinfoTemplate != "" {
+		if _, err := renderPushTemplate(config.PushinfoTemplate, data); err != nil {
+			return fmt.Errorf("PushinfoTemplate配置错误: %v", err)
+		}
+	}
+	return nil
+}
+
+// latestHistoryMessage 获取订阅最近一条历史条目，没有时返回false
+func latestHistoryMessage(db *sql.DB, feedID int) (Message, bool, error) {
+	var msg Message
+	var categories, published string
+	err := db.QueryRow(`SELECT title, link, description, content, author, categories, published_at
+		FROM item_history WHERE feed_id = ? ORDER BY published_at DESC, item_id DESC LIMIT 1`, feedID).
+		Scan(&msg.Title, &msg.Link, &msg.Description, &msg.Content, &msg.Author, &categories, &published)
+	if err == sql.ErrNoRows {
+		return msg, false, nil
+	}
+	if err != nil {
+		return msg, false, err
+	}
+	json.Unmarshal([]byte(categories), &msg.Categories)
+	msg.PubDate, _ = time.Parse("2006-01-02 15:04:05", published)
+	return msg, true, nil
+}
+
+// previewData 用订阅最近一条内容构造预览数据，没有历史内容时使用示例条目
+func previewData(userID int64, sub *SubscriptionInfo) (pushTemplateData, bool) {
+	msg := sampleMessage()
+	found := false
+	var keywords []string
+	withDB(func(db *sql.DB) error {
+		latest, ok, err := latestHistoryMessage(db, sub.ID)
+		if err != nil || !ok {
+			return err
+		}
+		msg, found = latest, true
+		if matcher, err := getUserMatcher(db, userID); err == nil {
+			keywords = matchesKeywords(newMessageContent(msg), matcher.rulesFor(sub.ID), sub.Name)
+		}
+		return nil
+	})
+	if len(keywords) == 0 {
+		keywords = []string{"示例关键词"}
+	}
+	return newPushTemplateData(msg, sub.Name, keywords, userLocation(userID), true), found
+}
+
+// setSubscriptionTemplate 保存用户订阅的推送模板，为空表示使用默认模板
+func setSubscriptionTemplate(userID int64, feedID int, text string) error {
+	return withDB(func(db *sql.DB) error {
+		_, err := db.Exec("UPDATE user_subscriptions SET template = ? WHERE user_id = ? AND feed_id = ?", text, userID, feedID)
+		return err
+	})
+}
+
+// promptSubscriptionTemplate 提示输入订阅的推送模板
+func (h *UserActionHandler) promptSubscriptionTemplate(userID int64, messageID int, subscriptionID int) {
+	sub, err := findSubscriptionForUser(userID, subscriptionID)
+	if err != nil {
+		h.sender.SendError(userID, messageID, "未找到该订阅，可能已被删除")
+		return
+	}
+
+	current := sub.Template
+	if current == "" {
+		current = defaultTemplateFor(sub.Channel) + "\n(默认模板)"
+	}
+	setUserState(userID, "set_sub_template", messageID, map[string]interface{}{"subscription_id": subscriptionID})
+	text := fmt.Sprintf("请输入订阅 \"%s\" 的推送模板，输出需为 Telegram HTML，保存前会用该订阅最近一条内容校验：\n\n%s\n\n当前模板：\n%s\n\n💡 发送 默认 恢复默认模板\n💡 使用 /preview %s 预览当前模板",
+		sub.Name, templateHelp, current, sub.Name)
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🔙 返回订阅详情", fmt.Sprintf("sub_detail_%d", subscriptionID)),
+		),
+	)
+	h.sender.SendResponse(userID, messageID, text, &keyboard)
+}
+
+// saveSubscriptionTemplate 校验并保存订阅的推送模板，成功后发送预览
+func (h *UserActionHandler) saveSubscriptionTemplate(userID int64, subscriptionID int, text string) {
+	sub, err := findSubscriptionForUser(userID, subscriptionID)
+	if err != nil {
+		clearUserState(userID)
+		h.sender.SendError(userID, 0, "未找到该订阅，可能已被删除")
+		return
+	}
+
+	var rendered string
+	if text == "默认" {
+		text = ""
+	} else {
+		data, _ := previewData(userID, sub)
+		rendered, err = checkPushTemplate(text, data)
+		if err != nil {
+			h.sender.SendError(userID, 0, "❌ "+err.Error()+"\n本次未保存，请修改后重新发送")
+			return
+		}
+	}
+
+	if err := setSubscriptionTemplate(userID, subscriptionID, text); err != nil {
+		logMessage("error", fmt.Sprintf("保存推送模板失败: %v", err), userID)
+		h.sender.SendError(userID, 0, "保存推送模板失败，请稍后重试")
+		return
+	}
+	clearUserState(userID)
+	if rendered != "" {
+		h.sender.SendHTMLResponse(userID, 0, "✅ 推送模板已保存，预览：\n\n"+rendered, nil, true)
+	}
+	h.showSubscriptionDetail(userID, 0, subscriptionID)
+}
+
+// handlePreviewCommand 处理 /preview 命令，用订阅最近一条内容预览推送效果
+func handlePreviewCommand(userID int64, args string) {
+	name := strings.TrimSpace(args)
+	subscriptions, err := getSubscriptionsForUser(userID)
+	if err != nil {
+		logMessage("error", fmt.Sprintf("获取用户订阅失败: %v", err), userID)
+		messageSender.SendError(userID, 0, "获取订阅失败，请稍后重试")
+		return
+	}
+	if name == "" && len(subscriptions) != 1 {
+		messageSender.SendError(userID, 0, "请指定要预览的订阅名称，例如：/preview 科技新闻")
+		return
+	}
+
+	var sub *SubscriptionInfo
+	for i := range subscriptions {
+		if name == "" || subscriptions[i].Name == name {
+			sub = &subscriptions[i]
+			break
+		}
+	}
+	if sub == nil {
+		messageSender.SendError(userID, 0, fmt.Sprintf("未找到名为 \"%s\" 的订阅", name))
+		return
+	}
+
+	data, found := previewData(userID, sub)
+	rendered := renderPush(sub.Template, sub.Channel, data)
+	if err := validateTelegramHTML(rendered); err != nil {
+		messageSender.SendError(userID, 0, fmt.Sprintf("❌ 当前模板的渲染结果不是有效的 Telegram HTML: %v", err))
+		return
+	}
+	note := "👀 预览（该订阅最近一条内容）："
+	if !found {
+		note = "👀 预览（该订阅暂无历史内容，使用示例内容）："
+	}
+	keyboard := CreateBackButton()
+	messageSender.SendHTMLResponse(userID, 0, note+"\n\n"+rendered, &keyboard, true)
+}
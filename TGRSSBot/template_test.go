@@ -0,0 +1,80 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateTelegramHTML(t *testing.T) {
+	tests := []struct {
+		text string
+		ok   bool
+	}{
+		{"纯文本", true},
+		{"<b>粗体</b> <i>斜体</i> <u>下划线</u> <s>删除</s>", true},
+		{`<a href="https://example.com/?a=1&amp;b=2">链接</a>`, true},
+		{`<code class="language-go">x</code>`, true},
+		{"<pre><code>x</code></pre>", true},
+		{"<blockquote expandable>引用</blockquote>", true},
+		{`<span class="tg-spoiler">剧透</span> <tg-spoiler>剧透</tg-spoiler>`, true},
+		{"&lt;RTX 4090&gt; &amp; &quot;显卡&quot; &#128512; &#x1F600;", true},
+		{"<B>大写标签</B>", true},
+		{"AT&T", false},
+		{"a & b", false},
+		{"&nbsp;", false},
+		{"价格 < 3000", false},
+		{"<b>未闭合", false},
+		{"</b>", false},
+		{"<b><i>交叉</b></i>", false},
+		{"<div>不支持</div>", false},
+		{"<br>", false},
+		{`<a href="https://example.com" target="_blank">链接</a>`, false},
+		{`<b class="x">粗体</b>`, false},
+	}
+	for _, tt := range tests {
+		if err := validateTelegramHTML(tt.text); (err == nil) != tt.ok {
+			t.Errorf("validateTelegramHTML(%q) = %v, want ok %v", tt.text, err, tt.ok)
+		}
+	}
+}
+
+func TestCheckPushTemplate(t *testing.T) {
+	data := newPushTemplateData(sampleMessage(), "示例订阅", []string{"显卡"}, time.UTC, true)
+	tests := []struct {
+		template string
+		ok       bool
+	}{
+		{DefaultPushTemplate, true},
+		{DefaultChannelTemplate, true},
+		{"<b>{{.Title}}</b>\n<a href=\"{{.Link}}\">原文</a>", true},
+		{"{{.Title}} {{.Keywords}} {{.Description}} {{.Image}}", true},
+		{"{{.Title", false},
+		{"{{.Unknown}}", false},
+		{"<b>{{.Title}}", false},
+		{"{{.Title}} & {{.Feed}}", false},
+		{"   ", false},
+		{strings.Repeat("x", MaxTemplateLength+1), false},
+	}
+	for _, tt := range tests {
+		rendered, err := checkPushTemplate(tt.template, data)
+		if (err == nil) != tt.ok {
+			t.Errorf("checkPushTemplate(%q) = %q, %v, want ok %v", tt.template, rendered, err, tt.ok)
+		}
+	}
+}
+
+func TestRenderPushFallback(t *testing.T) {
+	data := newPushTemplateData(Message{Title: "标题 <b>", Link: "https://example.com/?a=1&b=2"}, "订阅", nil, time.UTC, true)
+	got := renderPush("{{.Missing}}", 0, data)
+	want, err := renderPushTemplate(DefaultPushTemplate, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("renderPush with broken template = %q, want default %q", got, want)
+	}
+	if err := validateTelegramHTML(got); err != nil {
+		t.Errorf("default template output is not valid Telegram HTML: %v", err)
+	}
+}